@@ -0,0 +1,159 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Stats summarises one measure over a group of history entries
+type Stats struct {
+	Total   float64 `json:"total"`
+	Average float64 `json:"average"`
+	Median  float64 `json:"median"`
+}
+
+// PeriodTrend aggregates the current history of one period
+type PeriodTrend struct {
+	PeriodID        uint           `json:"periodId"`
+	Year            int            `json:"year"`
+	Month           int            `json:"month"`
+	PeriodMonth     string         `json:"periodMonth"`
+	Entries         int            `json:"entries"`
+	Bonus           Stats          `json:"bonus"`     // as calculated
+	PaidBonus       Stats          `json:"paidBonus"` // after payroll runs: the adjusted bonus where one was recorded
+	Points          Stats          `json:"points"`
+	KpiIndicators   map[string]int `json:"kpiIndicators"`   // entries per KPI indicator name
+	OmsetIndicators map[string]int `json:"omsetIndicators"` // entries per omset indicator name
+}
+
+// KpiAverage is the mean result of one KPI over a group of history entries
+type KpiAverage struct {
+	KpiID        uint    `json:"kpiId"`
+	Name         string  `json:"name"`
+	Platform     string  `json:"platform"`
+	PeriodID     *uint   `json:"periodId,omitempty"`
+	EmployeeID   *uint   `json:"employeeId,omitempty"`
+	Entries      int     `json:"entries"`
+	AvgScore     float64 `json:"avgScore"`
+	MinScore     float64 `json:"minScore"`
+	MaxScore     float64 `json:"maxScore"`
+	AvgPoin      float64 `json:"avgPoin"`
+	AvgRealisasi float64 `json:"avgRealisasi"`
+}
+
+// analyticsHistory selects the current revisions the caller may read, filtered by
+// division_id, employee_id, status and the period parameters of filterHistoryPeriod
+func analyticsHistory(c *gin.Context) (*gorm.DB, bool) {
+	q := scopeHistory(db.Model(&HistoryEntry{}), currentUser(c)).Where("is_current = ? AND period_id IS NOT NULL", true)
+	if v := c.Query("division_id"); v != "" { q = q.Where("division_id = ?", v) }
+	if v := c.Query("employee_id"); v != "" { q = q.Where("employee_id = ?", v) }
+	if v := c.Query("status"); v != "" { q = q.Where("status = ?", v) }
+	q, ok := filterHistoryPeriod(c, q)
+	return q.Session(&gorm.Session{}), ok
+}
+
+// medianSQL picks the middle row (or the mean of the two middle rows) of a ROW_NUMBER ranking
+func medianSQL(column, rank string) string {
+	return "AVG(CASE WHEN " + rank + " IN ((cnt + 1) / 2, (cnt + 2) / 2) THEN " + column + " END)"
+}
+
+func periodTrends(q *gorm.DB) ([]PeriodTrend, error) {
+	ranked := q.Select("period_id, bonus, COALESCE(adjusted_bonus, bonus) AS paid_bonus, total_points, " +
+		"ROW_NUMBER() OVER (PARTITION BY period_id ORDER BY bonus) AS bonus_rank, " +
+		"ROW_NUMBER() OVER (PARTITION BY period_id ORDER BY COALESCE(adjusted_bonus, bonus)) AS paid_rank, " +
+		"ROW_NUMBER() OVER (PARTITION BY period_id ORDER BY total_points) AS points_rank, " +
+		"COUNT(*) OVER (PARTITION BY period_id) AS cnt")
+	var rows []struct {
+		PeriodID                                 uint
+		Year, Month, Entries                     int
+		BonusTotal, BonusAverage, BonusMedian    float64
+		PaidTotal, PaidAverage, PaidMedian       float64
+		PointsTotal, PointsAverage, PointsMedian float64
+	}
+	err := db.Table("(?) AS h", ranked).Joins("JOIN periods ON periods.id = h.period_id").
+		Select("h.period_id, periods.year, periods.month, COUNT(*) AS entries, " +
+			"SUM(h.bonus) AS bonus_total, AVG(h.bonus) AS bonus_average, " + medianSQL("h.bonus", "h.bonus_rank") + " AS bonus_median, " +
+			"SUM(h.paid_bonus) AS paid_total, AVG(h.paid_bonus) AS paid_average, " + medianSQL("h.paid_bonus", "h.paid_rank") + " AS paid_median, " +
+			"SUM(h.total_points) AS points_total, AVG(h.total_points) AS points_average, " + medianSQL("h.total_points", "h.points_rank") + " AS points_median").
+		Group("h.period_id").Order("periods.year, periods.month").Scan(&rows).Error
+	if err != nil { return nil, err }
+
+	trends := make([]PeriodTrend, 0, len(rows))
+	index := map[uint]int{}
+	for _, r := range rows {
+		index[r.PeriodID] = len(trends)
+		trends = append(trends, PeriodTrend{
+			PeriodID: r.PeriodID, Year: r.Year, Month: r.Month, PeriodMonth: Period{Month: r.Month}.MonthName(), Entries: r.Entries,
+			Bonus:     Stats{Total: r.BonusTotal, Average: r.BonusAverage, Median: r.BonusMedian},
+			PaidBonus: Stats{Total: r.PaidTotal, Average: r.PaidAverage, Median: r.PaidMedian},
+			Points:    Stats{Total: r.PointsTotal, Average: r.PointsAverage, Median: r.PointsMedian},
+			KpiIndicators: map[string]int{}, OmsetIndicators: map[string]int{},
+		})
+	}
+
+	indicators := map[string]func(*PeriodTrend) map[string]int{
+		"$.kpiIndicator.name":   func(t *PeriodTrend) map[string]int { return t.KpiIndicators },
+		"$.omsetIndicator.name": func(t *PeriodTrend) map[string]int { return t.OmsetIndicators },
+	}
+	for path, counts := range indicators {
+		var rows []struct {
+			PeriodID uint
+			Name     string
+			N        int
+		}
+		err := db.Table("(?) AS h", q.Select("period_id, results_json")).
+			Select("period_id, COALESCE(json_extract(results_json, ?), '') AS name, COUNT(*) AS n", path).
+			Group("period_id, name").Scan(&rows).Error
+		if err != nil { return nil, err }
+		for _, r := range rows {
+			if i, ok := index[r.PeriodID]; ok { counts(&trends[i])[r.Name] = r.N }
+		}
+	}
+	return trends, nil
+}
+
+// kpiAverages aggregates the stored KPI results of q, per KPI and optionally also per period
+// or employee
+func kpiAverages(q *gorm.DB, groupBy string) ([]KpiAverage, error) {
+	group := "history_kpi_results.kpi_id"
+	switch groupBy {
+	case "period":
+		group = "history_entries.period_id, history_kpi_results.kpi_id"
+	case "employee":
+		group = "history_entries.employee_id, history_kpi_results.kpi_id"
+	}
+	var rows []KpiAverage
+	err := q.Select(group + ", MAX(history_kpi_results.name) AS name, MAX(history_kpi_results.platform) AS platform, COUNT(*) AS entries, " +
+		"AVG(history_kpi_results.score) AS avg_score, MIN(history_kpi_results.score) AS min_score, MAX(history_kpi_results.score) AS max_score, " +
+		"AVG(history_kpi_results.poin) AS avg_poin, AVG(history_kpi_results.realisasi) AS avg_realisasi").
+		Group(group).Order(group).Scan(&rows).Error
+	return rows, err
+}
+
+func registerAnalyticsRoutes(r *gin.RouterGroup) {
+	// GET /analytics/trends?division_id=&employee_id=&status=&from=&to=... returns, per period,
+	// totals, averages and medians of the calculated bonus, the bonus paid after payroll runs and
+	// points, and how many entries reached each indicator, over the current history the caller may read
+	r.GET("/analytics/trends", func(c *gin.Context) {
+		q, ok := analyticsHistory(c)
+		if !ok { return }
+		trends, err := periodTrends(q)
+		if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()}); return }
+		c.JSON(http.StatusOK, gin.H{"periods": trends})
+	})
+
+	// GET /analytics/kpis?division_id=&employee_id=&group_by=period|employee&... returns the
+	// average score, poin and realisasi of each KPI over the same history; the KPI filters of
+	// /kpi-results narrow which results are aggregated
+	r.GET("/analytics/kpis", func(c *gin.Context) {
+		groupBy := c.Query("group_by")
+		if groupBy != "" && groupBy != "period" && groupBy != "employee" { c.JSON(http.StatusBadRequest, gin.H{"error": "group_by must be period or employee"}); return }
+		q, ok := kpiResultsQuery(c)
+		if !ok { return }
+		rows, err := kpiAverages(q, groupBy)
+		if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()}); return }
+		c.JSON(http.StatusOK, gin.H{"kpis": rows})
+	})
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	AuditCreate = "create"
+	AuditUpdate = "update"
+	AuditDelete = "delete"
+)
+
+const (
+	defaultAuditPageSize = 50
+	maxAuditPageSize     = 200
+)
+
+// Fields that change on every write and carry no audit value
+var auditIgnoredFields = map[string]bool{"updatedAt": true, "createdAt": true}
+
+// toJSONMap flattens a model to its JSON field map; nil stays nil
+func toJSONMap(v any) map[string]any {
+	if v == nil || (reflect.ValueOf(v).Kind() == reflect.Ptr && reflect.ValueOf(v).IsNil()) { return nil }
+	b, err := json.Marshal(v)
+	if err != nil { return nil }
+	var m map[string]any
+	_ = json.Unmarshal(b, &m)
+	return m
+}
+
+// auditDiff lists every field whose value differs between before and after
+func auditDiff(before, after map[string]any) map[string]any {
+	diff := map[string]any{}
+	for k, av := range after {
+		if auditIgnoredFields[k] { continue }
+		if bv, ok := before[k]; !ok || !reflect.DeepEqual(bv, av) { diff[k] = gin.H{"from": before[k], "to": av} }
+	}
+	for k, bv := range before {
+		if _, ok := after[k]; !ok && !auditIgnoredFields[k] { diff[k] = gin.H{"from": bv, "to": nil} }
+	}
+	return diff
+}
+
+func jsonString(v any) string {
+	if v == nil { return "" }
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+// recordAudit writes an AuditLog row in tx; the actor comes from the request context the
+// transaction was started with (db.WithContext(c)), falling back to "system".
+func recordAudit(tx *gorm.DB, action, entityType string, before, after any) error {
+	bm, am := toJSONMap(before), toJSONMap(after)
+	ref := am
+	if ref == nil { ref = bm }
+
+	entry := AuditLog{Action: action, EntityType: entityType, Actor: "system"}
+	if id, ok := ref["id"].(float64); ok { entry.EntityID = uint(id) }
+	if did, ok := ref["divisionId"].(float64); ok && did > 0 {
+		d := uint(did)
+		entry.DivisionID = &d
+	} else if entityType == "division" {
+		d := entry.EntityID
+		entry.DivisionID = &d
+	}
+	if ctx := tx.Statement.Context; ctx != nil {
+		if u, ok := ctx.Value(userContextKey).(*User); ok && u != nil {
+			entry.ActorID, entry.Actor = &u.ID, u.Username
+		}
+	}
+	entry.BeforeJSON, entry.AfterJSON = jsonString(bm), jsonString(am)
+	if bm != nil && am != nil { entry.DiffJSON = jsonString(auditDiff(bm, am)) }
+	return tx.Create(&entry).Error
+}
+
+// auditHook records creates, updates and deletes made through the generic CRUD helpers
+func auditHook[T any](entityType string) writeHook[T] {
+	return func(tx *gorm.DB, before, after *T) error {
+		action := AuditUpdate
+		if before == nil { action = AuditCreate } else if after == nil { action = AuditDelete }
+		var b, a any
+		if before != nil { b = before }
+		if after != nil { a = after }
+		return recordAudit(tx, action, entityType, b, a)
+	}
+}
+
+// chainHooks runs hooks in order, stopping at the first error
+func chainHooks[T any](hooks ...writeHook[T]) writeHook[T] {
+	return func(tx *gorm.DB, before, after *T) error {
+		for _, h := range hooks {
+			if h == nil { continue }
+			if err := h(tx, before, after); err != nil { return err }
+		}
+		return nil
+	}
+}
+
+// deleteAudited deletes every T matching the condition, auditing each row
+func deleteAudited[T any](tx *gorm.DB, entityType string, query string, args ...any) error {
+	var rows []T
+	if err := tx.Where(query, args...).Find(&rows).Error; err != nil { return err }
+	for i := range rows {
+		if err := tx.Delete(&rows[i]).Error; err != nil { return err }
+		if err := recordAudit(tx, AuditDelete, entityType, &rows[i], nil); err != nil { return err }
+	}
+	return nil
+}
+
+// AuditLogResponse exposes an AuditLog with its JSON payloads decoded
+type AuditLogResponse struct {
+	AuditLog
+	Before json.RawMessage `json:"before"`
+	After  json.RawMessage `json:"after"`
+	Diff   json.RawMessage `json:"diff"`
+}
+
+func rawOrNull(s string) json.RawMessage {
+	if s == "" { return json.RawMessage("null") }
+	return json.RawMessage(s)
+}
+
+// parseTimeParam accepts RFC3339 or YYYY-MM-DD
+func parseTimeParam(v string) (time.Time, bool) {
+	if t, err := time.Parse(time.RFC3339, v); err == nil { return t, true }
+	if t, err := time.Parse("2006-01-02", v); err == nil { return t, true }
+	return time.Time{}, false
+}
+
+func registerAuditRoutes(r *gin.RouterGroup) {
+	// GET /audit?entity_type=&entity_id=&action=&actor=&division_id=&from=&to=&page=&page_size=
+	// Managers only see entries of their own division.
+	r.GET("/audit", requireRole(RoleAdmin, RoleManager), func(c *gin.Context) {
+		q := db.Model(&AuditLog{})
+		if u := currentUser(c); u.Role == RoleManager {
+			if u.DivisionID == nil { q = q.Where("1 = 0") } else { q = q.Where("division_id = ?", *u.DivisionID) }
+		}
+		if v := c.Query("entity_type"); v != "" { q = q.Where("entity_type = ?", v) }
+		if v := c.Query("entity_id"); v != "" { q = q.Where("entity_id = ?", v) }
+		if v := c.Query("action"); v != "" { q = q.Where("action = ?", v) }
+		if v := c.Query("actor"); v != "" { q = q.Where("actor = ?", v) }
+		if v := c.Query("division_id"); v != "" { q = q.Where("division_id = ?", v) }
+		if v := c.Query("from"); v != "" {
+			t, ok := parseTimeParam(v)
+			if !ok { c.JSON(http.StatusBadRequest, gin.H{"error": "invalid from"}); return }
+			q = q.Where("created_at >= ?", t)
+		}
+		if v := c.Query("to"); v != "" {
+			t, ok := parseTimeParam(v)
+			if !ok { c.JSON(http.StatusBadRequest, gin.H{"error": "invalid to"}); return }
+			q = q.Where("created_at <= ?", t)
+		}
+
+		page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+		if page < 1 { page = 1 }
+		pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", strconv.Itoa(defaultAuditPageSize)))
+		if pageSize < 1 { pageSize = defaultAuditPageSize }
+		if pageSize > maxAuditPageSize { pageSize = maxAuditPageSize }
+
+		var total int64
+		if err := q.Count(&total).Error; err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()}); return }
+		var logs []AuditLog
+		if err := q.Order("created_at desc, id desc").Offset((page - 1) * pageSize).Limit(pageSize).Find(&logs).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()}); return
+		}
+		items := make([]AuditLogResponse, 0, len(logs))
+		for _, l := range logs {
+			items = append(items, AuditLogResponse{AuditLog: l, Before: rawOrNull(l.BeforeJSON), After: rawOrNull(l.AfterJSON), Diff: rawOrNull(l.DiffJSON)})
+		}
+		c.JSON(http.StatusOK, gin.H{"items": items, "total": total, "page": page, "pageSize": pageSize})
+	})
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+const (
+	RoleAdmin    = "admin"
+	RoleHR       = "hr"
+	RoleManager  = "manager"
+	RoleEmployee = "employee"
+)
+
+const (
+	sessionTTL        = 12 * time.Hour
+	minPasswordLength = 8
+	userContextKey    = "user"
+)
+
+var validRoles = map[string]bool{RoleAdmin: true, RoleHR: true, RoleManager: true, RoleEmployee: true}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func newToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil { return "", err }
+	return hex.EncodeToString(b), nil
+}
+
+// currentUser returns the user set by authRequired
+func currentUser(c *gin.Context) *User {
+	if v, ok := c.Get(userContextKey); ok {
+		if u, ok := v.(*User); ok { return u }
+	}
+	return nil
+}
+
+// canManageDivision reports whether u may write history for the division
+func (u *User) canManageDivision(divisionID uint) bool {
+	switch u.Role {
+	case RoleAdmin:
+		return true
+	case RoleManager:
+		return u.DivisionID != nil && *u.DivisionID == divisionID
+	}
+	return false
+}
+
+// canReadDivision reports whether u may see the configuration of a division
+func (u *User) canReadDivision(divisionID uint) bool { return u.Role == RoleHR || u.canManageDivision(divisionID) }
+
+// canReadHistory reports whether u may see a history entry
+func (u *User) canReadHistory(entry HistoryEntry) bool {
+	if u.Role == RoleHR { return true }
+	if u.Role == RoleEmployee { return u.EmployeeID != nil && *u.EmployeeID == entry.EmployeeID }
+	return u.canManageDivision(entry.DivisionID)
+}
+
+// scopeHistory restricts a HistoryEntry query to the rows u may read
+func scopeHistory(q *gorm.DB, u *User) *gorm.DB {
+	switch u.Role {
+	case RoleAdmin, RoleHR:
+		return q
+	case RoleManager:
+		if u.DivisionID == nil { return q.Where("1 = 0") }
+		return q.Where("division_id = ?", *u.DivisionID)
+	case RoleEmployee:
+		if u.EmployeeID == nil { return q.Where("1 = 0") }
+		return q.Where("employee_id = ?", *u.EmployeeID)
+	}
+	return q.Where("1 = 0")
+}
+
+// scopeDivisions restricts a query on division data to the divisions u may read (canReadDivision);
+// column holds the division id of a row
+func scopeDivisions(q *gorm.DB, u *User, column string) *gorm.DB {
+	switch u.Role {
+	case RoleAdmin, RoleHR:
+		return q
+	case RoleManager:
+		if u.DivisionID == nil { return q.Where("1 = 0") }
+		return q.Where(column+" = ?", *u.DivisionID)
+	}
+	return q.Where("1 = 0")
+}
+
+// authRequired resolves the bearer token to a user or aborts with 401
+func authRequired() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := strings.TrimSpace(strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer "))
+		if token == "" { c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "authentication required"}); return }
+		var session Session
+		if err := db.Where("token_hash = ? AND expires_at > ?", hashToken(token), time.Now()).First(&session).Error; err != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid or expired session"}); return
+		}
+		var user User
+		if err := db.First(&user, session.UserID).Error; err != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid or expired session"}); return
+		}
+		c.Set(userContextKey, &user)
+		c.Next()
+	}
+}
+
+// requireRole aborts with 403 unless the current user has one of the roles
+func requireRole(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		u := currentUser(c)
+		for _, role := range roles {
+			if u != nil && u.Role == role { c.Next(); return }
+		}
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "insufficient permissions"})
+	}
+}
+
+// corsMiddleware echoes allowed origins from CORS_ALLOWED_ORIGINS (comma-separated, "*" allows any)
+func corsMiddleware() gin.HandlerFunc {
+	allowed := map[string]bool{}
+	origins := os.Getenv("CORS_ALLOWED_ORIGINS")
+	if origins == "" { origins = "http://localhost:5173,http://localhost:3000" }
+	for _, o := range strings.Split(origins, ",") {
+		if o = strings.TrimSpace(o); o != "" { allowed[o] = true }
+	}
+	return func(c *gin.Context) {
+		origin := c.GetHeader("Origin")
+		if origin != "" && (allowed["*"] || allowed[origin]) {
+			c.Header("Access-Control-Allow-Origin", origin)
+			c.Header("Vary", "Origin")
+		}
+		c.Header("Access-Control-Allow-Methods", "GET,POST,PUT,PATCH,DELETE,OPTIONS")
+		c.Header("Access-Control-Allow-Headers", "Content-Type,Authorization")
+		if c.Request.Method == http.MethodOptions { c.AbortWithStatus(http.StatusNoContent); return }
+		c.Next()
+	}
+}
+
+// prepareUser validates role bindings and hashes a new password when one is given
+func prepareUser(id uint, u *User, password string) error {
+	u.Username = strings.TrimSpace(u.Username)
+	if u.Username == "" { return errors.New("username is required") }
+	if !validRoles[u.Role] { return fmt.Errorf("invalid role %q", u.Role) }
+	var cnt int64
+	if err := db.Model(&User{}).Where("username = ? AND id <> ?", u.Username, id).Count(&cnt).Error; err != nil { return err }
+	if cnt > 0 { return fmt.Errorf("username %q already exists", u.Username) }
+	switch u.Role {
+	case RoleAdmin, RoleHR:
+		u.DivisionID, u.EmployeeID = nil, nil
+	case RoleManager:
+		if u.DivisionID == nil { return errors.New("divisionId is required for managers") }
+		if err := requireDivision(*u.DivisionID); err != nil { return err }
+		u.EmployeeID = nil
+	case RoleEmployee:
+		if u.EmployeeID == nil { return errors.New("employeeId is required for employees") }
+		var emp Employee
+		if err := db.First(&emp, *u.EmployeeID).Error; err != nil { return fmt.Errorf("employee %d not found", *u.EmployeeID) }
+		u.DivisionID = &emp.DivisionID
+	}
+	if password != "" || id == 0 {
+		if len(password) < minPasswordLength { return fmt.Errorf("password must be at least %d characters", minPasswordLength) }
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		if err != nil { return err }
+		u.PasswordHash = string(hash)
+	}
+	return nil
+}
+
+// ensureAdminUser creates the first admin from ADMIN_USERNAME/ADMIN_PASSWORD when no users exist
+func ensureAdminUser() {
+	var count int64
+	db.Model(&User{}).Count(&count)
+	if count > 0 { return }
+	username := os.Getenv("ADMIN_USERNAME")
+	if username == "" { username = "admin" }
+	password := os.Getenv("ADMIN_PASSWORD")
+	if password == "" {
+		token, err := newToken()
+		if err != nil { log.Fatalf("failed to generate admin password: %v", err) }
+		password = token[:16]
+		log.Printf("Created admin user %q with generated password %s; change it after first login", username, password)
+	}
+	admin := User{Username: username, Role: RoleAdmin}
+	if err := prepareUser(0, &admin, password); err != nil { log.Fatalf("failed to prepare admin user: %v", err) }
+	if err := db.Create(&admin).Error; err != nil { log.Fatalf("failed to create admin user: %v", err) }
+}
+
+// UserRequest is the write payload for /users; password is optional on update
+type UserRequest struct {
+	Username   string `json:"username"`
+	Password   string `json:"password"`
+	Role       string `json:"role"`
+	DivisionID *uint  `json:"divisionId"`
+	EmployeeID *uint  `json:"employeeId"`
+}
+
+func registerAuthRoutes(public *gin.RouterGroup, api *gin.RouterGroup) {
+	public.POST("/auth/login", func(c *gin.Context) {
+		var req struct {
+			Username string `json:"username"`
+			Password string `json:"password"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()}); return }
+		var user User
+		if err := db.Where("username = ?", strings.TrimSpace(req.Username)).First(&user).Error; err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid username or password"}); return
+		}
+		if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.Password)) != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid username or password"}); return
+		}
+		token, err := newToken()
+		if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()}); return }
+		session := Session{TokenHash: hashToken(token), UserID: user.ID, ExpiresAt: time.Now().Add(sessionTTL)}
+		if err := db.Create(&session).Error; err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()}); return }
+		db.Where("expires_at <= ?", time.Now()).Delete(&Session{})
+		c.JSON(http.StatusOK, gin.H{"token": token, "expiresAt": session.ExpiresAt, "user": user})
+	})
+
+	api.POST("/auth/logout", func(c *gin.Context) {
+		token := strings.TrimSpace(strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer "))
+		if err := db.Where("token_hash = ?", hashToken(token)).Delete(&Session{}).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()}); return
+		}
+		c.Status(http.StatusNoContent)
+	})
+	api.GET("/auth/me", func(c *gin.Context) { c.JSON(http.StatusOK, currentUser(c)) })
+
+	admin := requireRole(RoleAdmin)
+	api.GET("/users", admin, func(c *gin.Context) { listRecords[User](c, false) })
+	api.POST("/users", admin, func(c *gin.Context) {
+		var req UserRequest
+		if err := c.ShouldBindJSON(&req); err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()}); return }
+		user := User{Username: req.Username, Role: req.Role, DivisionID: req.DivisionID, EmployeeID: req.EmployeeID}
+		if err := prepareUser(0, &user, req.Password); err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()}); return }
+		if err := db.Create(&user).Error; err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()}); return }
+		c.JSON(http.StatusCreated, user)
+	})
+	// PUT /users/:id replaces username, role and bindings; password is only changed when given
+	api.PUT("/users/:id", admin, func(c *gin.Context) {
+		id, ok := parseID(c)
+		if !ok { return }
+		var user User
+		if err := db.First(&user, id).Error; err != nil { respondLookupError(c, "user", err); return }
+		var req UserRequest
+		if err := c.ShouldBindJSON(&req); err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()}); return }
+		user.Username, user.Role, user.DivisionID, user.EmployeeID = req.Username, req.Role, req.DivisionID, req.EmployeeID
+		if err := prepareUser(id, &user, req.Password); err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()}); return }
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Save(&user).Error; err != nil { return err }
+			// A password change signs the user out everywhere
+			if req.Password != "" { return tx.Where("user_id = ?", id).Delete(&Session{}).Error }
+			return nil
+		})
+		if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()}); return }
+		c.JSON(http.StatusOK, user)
+	})
+	api.DELETE("/users/:id", admin, func(c *gin.Context) {
+		id, ok := parseID(c)
+		if !ok { return }
+		if currentUser(c).ID == id { c.JSON(http.StatusBadRequest, gin.H{"error": "cannot delete your own account"}); return }
+		deleteRecord(c, "user", func(tx *gorm.DB, before, _ *User) error {
+			return tx.Where("user_id = ?", before.ID).Delete(&Session{}).Error
+		})
+	})
+}
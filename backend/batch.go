@@ -0,0 +1,233 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const maxBatchRows = 1000
+
+// Batch row statuses
+const (
+	BatchRowOK       = "ok"       // calculated; would be saved
+	BatchRowInvalid  = "invalid"  // unknown employee, duplicate row or unparsable inputs
+	BatchRowConflict = "conflict" // history already exists for the employee and period
+	BatchRowSaved    = "saved"
+)
+
+var errBatchRejected = errors.New("batch has invalid or conflicting rows; nothing was saved")
+
+// BatchRow is one employee's realisasi inputs in a batch calculation
+type BatchRow struct {
+	EmployeeID      uint            `json:"employeeId"`
+	RealisasiInputs map[uint]string `json:"realisasiInputs"`
+	Line            int             `json:"-"` // source line of an imported row
+	Error           string          `json:"-"` // problem found before calculation, e.g. by an import
+}
+
+// BatchCalculateRequest scores many employees of a division for one period
+type BatchCalculateRequest struct {
+	PeriodMonth string     `json:"periodMonth"`
+	PeriodYear  int        `json:"periodYear"`
+	Date        string     `json:"date"`
+	Persist     bool       `json:"persist"` // save every row as history in one transaction
+	Rows        []BatchRow `json:"rows"`
+}
+
+// BatchRowResult is the outcome of one row; Row is its index in the request
+type BatchRowResult struct {
+	Row             int                `json:"row"`
+	Line            int                `json:"line,omitempty"`
+	EmployeeID      uint               `json:"employeeId"`
+	EmployeeName    string             `json:"employeeName"`
+	Status          string             `json:"status"`
+	Error           string             `json:"error,omitempty"`
+	InputErrors     []KpiInputError    `json:"inputErrors,omitempty"`
+	Results         *CalculationResult `json:"results,omitempty"`
+	HistoryID       *uint              `json:"historyId,omitempty"`
+	RealisasiInputs map[uint]string    `json:"-"`
+	Grade           string             `json:"-"`
+}
+
+// BatchSummary counts rows by status
+type BatchSummary struct {
+	Total    int     `json:"total"`
+	OK       int     `json:"ok"`
+	Invalid  int     `json:"invalid"`
+	Conflict int     `json:"conflict"`
+	Saved    int     `json:"saved"`
+	Bonus    float64 `json:"bonus"` // sum of FinalBonus over calculable rows
+}
+
+func summarizeBatch(rows []BatchRowResult) BatchSummary {
+	s := BatchSummary{Total: len(rows)}
+	for _, r := range rows {
+		switch r.Status {
+		case BatchRowOK:
+			s.OK++
+		case BatchRowInvalid:
+			s.Invalid++
+		case BatchRowConflict:
+			s.Conflict++
+		case BatchRowSaved:
+			s.Saved++
+		}
+		if r.Results != nil { s.Bonus += r.Results.FinalBonus }
+	}
+	return s
+}
+
+// calculateBatch scores every row concurrently against cfg. Rows for unknown or repeated
+// employees and rows with unparsable inputs are marked invalid; existing history is a conflict.
+func calculateBatch(cfg DivisionConfig, employees map[uint]Employee, existing map[uint]bool, rows []BatchRow) []BatchRowResult {
+	out := make([]BatchRowResult, len(rows))
+	seen := map[uint]int{}
+	var pending []int
+	for i, row := range rows {
+		res := BatchRowResult{Row: i, Line: row.Line, EmployeeID: row.EmployeeID, RealisasiInputs: row.RealisasiInputs}
+		emp, ok := employees[row.EmployeeID]
+		switch {
+		case row.Error != "":
+			res.Status, res.Error = BatchRowInvalid, row.Error
+		case !ok:
+			res.Status, res.Error = BatchRowInvalid, "employee not found in division"
+		case len(row.RealisasiInputs) == 0:
+			res.Status, res.Error = BatchRowInvalid, "realisasiInputs is required"
+		default:
+			res.EmployeeName, res.Grade = emp.Name, emp.Grade
+			if first, dup := seen[row.EmployeeID]; dup {
+				res.Status, res.Error = BatchRowInvalid, fmt.Sprintf("employee already appears in row %d", first)
+				if rows[first].Line > 0 { res.Error = fmt.Sprintf("employee already appears on line %d", rows[first].Line) }
+			} else {
+				seen[row.EmployeeID] = i
+				pending = append(pending, i)
+			}
+		}
+		out[i] = res
+	}
+
+	workers := runtime.NumCPU()
+	if workers > len(pending) { workers = len(pending) }
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results := cfg.Calculate(out[i].Grade, rows[i].RealisasiInputs)
+				res := &out[i]
+				switch {
+				case len(results.InputErrors) > 0:
+					res.Status, res.Error, res.InputErrors = BatchRowInvalid, "invalid realisasi inputs", results.InputErrors
+				case existing[res.EmployeeID]:
+					res.Status, res.Error, res.Results = BatchRowConflict, errDuplicateHistory.Error(), &results
+				default:
+					res.Status, res.Results = BatchRowOK, &results
+				}
+			}
+		}()
+	}
+	for _, i := range pending { jobs <- i }
+	close(jobs)
+	wg.Wait()
+	return out
+}
+
+// existingHistoryEmployees returns the employees that already have history for the period
+func existingHistoryEmployees(tx *gorm.DB, divisionID uint, p Period) (map[uint]bool, error) {
+	var ids []uint
+	err := tx.Model(&HistoryEntry{}).Where("division_id = ? AND is_current = ? AND period_id = (SELECT id FROM periods WHERE year = ? AND month = ?)", divisionID, true, p.Year, p.Month).Pluck("employee_id", &ids).Error
+	existing := map[uint]bool{}
+	for _, id := range ids { existing[id] = true }
+	return existing, err
+}
+
+// persistBatch saves every row as a draft history entry, all or nothing. Conflicts are checked
+// again inside the transaction so a concurrent save cannot slip in between.
+func persistBatch(tx *gorm.DB, cfg DivisionConfig, period Period, date time.Time, rows []BatchRowResult) error {
+	existing, err := existingHistoryEmployees(tx, cfg.Division.ID, period)
+	if err != nil { return err }
+	rejected := false
+	for i := range rows {
+		if rows[i].Status == BatchRowOK && existing[rows[i].EmployeeID] {
+			rows[i].Status, rows[i].Error = BatchRowConflict, errDuplicateHistory.Error()
+		}
+		rejected = rejected || rows[i].Status != BatchRowOK
+	}
+	if rejected { return errBatchRejected }
+	for i := range rows {
+		entry, err := newHistoryEntry(cfg.Division.ID, rows[i].EmployeeID, rows[i].EmployeeName, rows[i].Grade, date, period, rows[i].RealisasiInputs, *rows[i].Results)
+		if err != nil { return err }
+		if err := createHistory(tx, cfg, &entry); err != nil { return err }
+		rows[i].Status, rows[i].HistoryID = BatchRowSaved, &entry.ID
+	}
+	return nil
+}
+
+// runBatch calculates and optionally persists a batch for a division, writing the response
+// with any extra fields merged in
+func runBatch(c *gin.Context, divisionID uint, req BatchCalculateRequest, extra gin.H) {
+	if strings.TrimSpace(req.PeriodMonth) == "" || req.PeriodYear == 0 { c.JSON(http.StatusBadRequest, gin.H{"error": "periodMonth and periodYear are required"}); return }
+	period, err := newPeriod(req.PeriodMonth, req.PeriodYear)
+	if err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()}); return }
+	if len(req.Rows) == 0 { c.JSON(http.StatusBadRequest, gin.H{"error": "rows is required"}); return }
+	if len(req.Rows) > maxBatchRows { c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("at most %d rows per batch", maxBatchRows)}); return }
+
+	if req.Persist {
+		if err := checkPeriodOpen(db, divisionID, period); err != nil { respondPeriodError(c, err); return }
+	}
+	cfg, err := loadDivisionConfig(db, divisionID)
+	if err != nil { respondLookupError(c, "division", err); return }
+	var list []Employee
+	if err := db.Where("division_id = ?", divisionID).Find(&list).Error; err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()}); return }
+	employees := map[uint]Employee{}
+	for _, e := range list { employees[e.ID] = e }
+	existing, err := existingHistoryEmployees(db, divisionID, period)
+	if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()}); return }
+
+	rows := calculateBatch(cfg, employees, existing, req.Rows)
+	status := http.StatusOK
+	if req.Persist {
+		date := time.Now()
+		if t, err := time.Parse(time.RFC3339, req.Date); err == nil { date = t }
+		err := db.WithContext(c).Transaction(func(tx *gorm.DB) error { return persistBatch(tx, cfg, period, date, rows) })
+		switch {
+		case errors.Is(err, errBatchRejected):
+			status = http.StatusConflict
+		case errors.Is(err, errPeriodClosed):
+			c.JSON(http.StatusLocked, gin.H{"error": err.Error()}); return
+		case errors.Is(err, errDuplicateHistory): // saved concurrently since the check above
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()}); return
+		case err != nil:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()}); return
+		default:
+			status = http.StatusCreated
+		}
+	}
+	body := gin.H{"divisionId": divisionID, "periodMonth": period.MonthName(), "periodYear": period.Year, "persisted": status == http.StatusCreated, "summary": summarizeBatch(rows), "rows": rows}
+	if status == http.StatusConflict { body["error"] = errBatchRejected.Error() }
+	for k, v := range extra { body[k] = v }
+	c.JSON(status, body)
+}
+
+func registerBatchRoutes(r *gin.RouterGroup) {
+	// POST /divisions/:id/calculate-batch scores many employees against the stored configuration;
+	// with "persist": true every row is saved as draft history, or none is when any row fails.
+	r.POST("/divisions/:id/calculate-batch", requireRole(RoleAdmin, RoleManager), func(c *gin.Context) {
+		id, ok := parseID(c)
+		if !ok { return }
+		if !currentUser(c).canManageDivision(id) { c.JSON(http.StatusForbidden, gin.H{"error": "not allowed to calculate for this division"}); return }
+		var req BatchCalculateRequest
+		if err := c.ShouldBindJSON(&req); err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()}); return }
+		runBatch(c, id, req, nil)
+	})
+}
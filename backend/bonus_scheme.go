@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// Scheme modes decide how the bonus schemes turn omset (or points) into a multiplier
+const (
+	SchemeModeStep        = "step"        // highest reached threshold wins (legacy)
+	SchemeModeLinear      = "linear"      // interpolate between the surrounding thresholds
+	SchemeModeProgressive = "progressive" // each tier's multiplier applies to its slice, like tax brackets
+)
+
+var validSchemeModes = map[string]bool{SchemeModeStep: true, SchemeModeLinear: true, SchemeModeProgressive: true}
+
+// Bonus base sources, reported in CalculationResult.BonusBaseSource
+const (
+	BonusBaseDefault  = "default"
+	BonusBaseDivision = "division"
+	BonusBaseGrade    = "grade"
+)
+
+// defaultBonusBaseRate is the rupiah-per-point base used before it became configurable
+const defaultBonusBaseRate = 1000.0
+
+// Variables a bonus formula may reference
+var bonusFormulaVars = map[string]bool{"points": true, "multiplier": true, "base": true, "omset": true, "omset_target": true}
+
+// BonusSettings are the division-level knobs of the final bonus step
+type BonusSettings struct {
+	SchemeMode string   `json:"schemeMode"`
+	BonusCap   *float64 `json:"bonusCap"`
+	BaseRate   *float64 `json:"baseRate"`   // nil uses defaultBonusBaseRate
+	BaseSource string   `json:"baseSource"` // default | division | grade
+	Formula    *string  `json:"formula"`
+}
+
+// resolveBonusBase picks the grade's rate when one exists, then the division's, then the default
+func resolveBonusBase(divisionRate *float64, gradeRates []GradeRate, grade string) (*float64, string) {
+	if grade = strings.TrimSpace(grade); grade != "" {
+		for _, g := range gradeRates {
+			if strings.EqualFold(g.Grade, grade) { rate := g.BaseRate; return &rate, BonusBaseGrade }
+		}
+	}
+	if divisionRate != nil { return divisionRate, BonusBaseDivision }
+	return nil, BonusBaseDefault
+}
+
+// ParseBonusFormula parses a final bonus formula; it may only use points, multiplier, base,
+// omset and omset_target
+func ParseBonusFormula(src string) (*Formula, error) {
+	f, err := ParseFormula(src)
+	if err != nil { return nil, fmt.Errorf("bonus formula: %w", err) }
+	for _, ref := range f.Refs() {
+		if !bonusFormulaVars[ref] { return nil, fmt.Errorf("bonus formula: unknown variable %q", ref) }
+	}
+	return f, nil
+}
+
+// finalBonus computes the bonus before capping, returning the base rate and the formula used
+func (s BonusSettings) finalBonus(points, multiplier, omset, omsetTarget float64) (float64, float64, string) {
+	base := defaultBonusBaseRate
+	if s.BaseRate != nil { base = *s.BaseRate }
+	if s.Formula != nil && strings.TrimSpace(*s.Formula) != "" {
+		// formulas are validated on save and by /calculate; an unparsable one falls back to the default
+		if f, err := ParseBonusFormula(*s.Formula); err == nil {
+			env := map[string]float64{"points": points, "multiplier": multiplier, "base": base, "omset": omset, "omset_target": omsetTarget}
+			return f.Eval(env), base, f.Source
+		}
+	}
+	return (points * base) * multiplier, base, ""
+}
+
+// SchemeTierDetail is one tier's share of the active multiplier. Weight is the fraction of the
+// tier's multiplier that applies; Contribution = Multiplier * Weight and they sum to ActiveMultiplier.
+type SchemeTierDetail struct {
+	SchemeID     uint     `json:"schemeId"`
+	Name         string   `json:"name"`
+	Threshold    float64  `json:"threshold"`
+	UpperBound   *float64 `json:"upperBound"` // next tier's threshold; nil for the top tier
+	Multiplier   float64  `json:"multiplier"`
+	Portion      float64  `json:"portion"` // source value falling inside this tier (progressive)
+	Weight       float64  `json:"weight"`
+	Contribution float64  `json:"contribution"`
+}
+
+// evaluateSchemes returns the multiplier for sourceValue, the highest reached tier (nil when
+// none is reached) and the per-tier breakdown. Schemes below the lowest threshold pay nothing.
+func evaluateSchemes(schemes []BonusScheme, sourceValue float64, mode string) (float64, *BonusScheme, []SchemeTierDetail) {
+	tiers := append([]BonusScheme(nil), schemes...)
+	sort.SliceStable(tiers, func(i, j int) bool { return tiers[i].Threshold < tiers[j].Threshold })
+	reached := -1
+	for i, s := range tiers {
+		if sourceValue >= s.Threshold { reached = i }
+	}
+	breakdown := []SchemeTierDetail{}
+	if reached < 0 { return 0, nil, breakdown }
+
+	tier := func(i int, portion, weight float64) SchemeTierDetail {
+		d := SchemeTierDetail{SchemeID: tiers[i].ID, Name: tiers[i].Name, Threshold: tiers[i].Threshold, Multiplier: tiers[i].Multiplier, Portion: portion, Weight: weight, Contribution: tiers[i].Multiplier * weight}
+		if i+1 < len(tiers) { upper := tiers[i+1].Threshold; d.UpperBound = &upper }
+		return d
+	}
+	active := &tiers[reached]
+
+	switch mode {
+	case SchemeModeLinear:
+		if reached+1 < len(tiers) && tiers[reached+1].Threshold > active.Threshold {
+			next := tiers[reached+1]
+			f := (sourceValue - active.Threshold) / (next.Threshold - active.Threshold)
+			breakdown = append(breakdown, tier(reached, 0, 1-f))
+			if f > 0 { breakdown = append(breakdown, tier(reached+1, 0, f)) }
+		} else {
+			breakdown = append(breakdown, tier(reached, 0, 1))
+		}
+	case SchemeModeProgressive:
+		// The slice below the lowest threshold earns no multiplier, so it only dilutes the average
+		if sourceValue > 0 {
+			for i := 0; i <= reached; i++ {
+				hi := sourceValue
+				if i < reached { hi = tiers[i+1].Threshold }
+				portion := hi - math.Max(tiers[i].Threshold, 0)
+				if portion <= 0 { continue }
+				breakdown = append(breakdown, tier(i, portion, portion/sourceValue))
+			}
+		}
+	default:
+		breakdown = append(breakdown, tier(reached, 0, 1))
+	}
+
+	multiplier := 0.0
+	for _, d := range breakdown { multiplier += d.Contribution }
+	return multiplier, active, breakdown
+}
+
+// applyBonusCap limits bonus to the cap, reporting whether it was applied
+func applyBonusCap(bonus float64, cap *float64) (float64, bool) {
+	if cap != nil && bonus > *cap { return *cap, true }
+	return bonus, false
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestEvaluateSchemes(t *testing.T) {
+	// Deliberately unsorted: evaluateSchemes orders tiers by threshold itself
+	tiers := []BonusScheme{
+		{ID: 3, Name: "C", Threshold: 400, Multiplier: 3},
+		{ID: 1, Name: "A", Threshold: 100, Multiplier: 1},
+		{ID: 2, Name: "B", Threshold: 200, Multiplier: 2},
+	}
+	tests := []struct {
+		name       string
+		schemes    []BonusScheme
+		mode       string
+		value      float64
+		multiplier float64
+		active     uint // 0 when no tier is reached
+		tiers      int  // breakdown rows
+	}{
+		{name: "step below lowest", schemes: tiers, mode: SchemeModeStep, value: 50, multiplier: 0, active: 0, tiers: 0},
+		{name: "step on threshold", schemes: tiers, mode: SchemeModeStep, value: 200, multiplier: 2, active: 2, tiers: 1},
+		{name: "step between", schemes: tiers, mode: SchemeModeStep, value: 399, multiplier: 2, active: 2, tiers: 1},
+		{name: "step top", schemes: tiers, mode: SchemeModeStep, value: 1000, multiplier: 3, active: 3, tiers: 1},
+		{name: "linear on threshold", schemes: tiers, mode: SchemeModeLinear, value: 200, multiplier: 2, active: 2, tiers: 1},
+		{name: "linear halfway", schemes: tiers, mode: SchemeModeLinear, value: 300, multiplier: 2.5, active: 2, tiers: 2},
+		{name: "linear top", schemes: tiers, mode: SchemeModeLinear, value: 500, multiplier: 3, active: 3, tiers: 1},
+		{name: "linear below lowest", schemes: tiers, mode: SchemeModeLinear, value: 99, multiplier: 0, active: 0, tiers: 0},
+		// 100..200 at 1 and 200..300 at 2, each a third of 300; below 100 earns nothing
+		{name: "progressive two tiers", schemes: tiers, mode: SchemeModeProgressive, value: 300, multiplier: 1, active: 2, tiers: 2},
+		// 100/500 at 1, 200/500 at 2, 100/500 at 3
+		{name: "progressive all tiers", schemes: tiers, mode: SchemeModeProgressive, value: 500, multiplier: 1.6, active: 3, tiers: 3},
+		{name: "progressive on lowest", schemes: tiers, mode: SchemeModeProgressive, value: 100, multiplier: 0, active: 1, tiers: 0},
+		{name: "progressive from zero", schemes: []BonusScheme{{ID: 1, Threshold: 0, Multiplier: 1}, {ID: 2, Threshold: 100, Multiplier: 2}}, mode: SchemeModeProgressive, value: 150, multiplier: 4.0 / 3, active: 2, tiers: 2},
+		{name: "progressive negative lowest", schemes: []BonusScheme{{ID: 1, Threshold: -50, Multiplier: 1}}, mode: SchemeModeProgressive, value: 100, multiplier: 1, active: 1, tiers: 1},
+		{name: "progressive zero value", schemes: []BonusScheme{{ID: 1, Threshold: 0, Multiplier: 1}}, mode: SchemeModeProgressive, value: 0, multiplier: 0, active: 1, tiers: 0},
+		{name: "no schemes", schemes: nil, mode: SchemeModeStep, value: 100, multiplier: 0, active: 0, tiers: 0},
+	}
+	for _, tt := range tests {
+		multiplier, active, breakdown := evaluateSchemes(tt.schemes, tt.value, tt.mode)
+		if math.Abs(multiplier-tt.multiplier) > 1e-9 { t.Errorf("%s: multiplier = %v, want %v", tt.name, multiplier, tt.multiplier) }
+		var activeID uint
+		if active != nil { activeID = active.ID }
+		if activeID != tt.active { t.Errorf("%s: active = %d, want %d", tt.name, activeID, tt.active) }
+		if len(breakdown) != tt.tiers { t.Errorf("%s: breakdown = %+v, want %d rows", tt.name, breakdown, tt.tiers) }
+		sum := 0.0
+		for _, d := range breakdown { sum += d.Contribution }
+		if math.Abs(sum-multiplier) > 1e-9 { t.Errorf("%s: contributions sum to %v, multiplier is %v", tt.name, sum, multiplier) }
+	}
+	if tiers[0].ID != 3 { t.Errorf("evaluateSchemes reordered its input") }
+}
+
+func TestApplyBonusCap(t *testing.T) {
+	limit := func(v float64) *float64 { return &v }
+	tests := []struct {
+		bonus  float64
+		cap    *float64
+		want   float64
+		capped bool
+	}{
+		{bonus: 500, cap: nil, want: 500},
+		{bonus: 500, cap: limit(1000), want: 500},
+		{bonus: 1000, cap: limit(1000), want: 1000},
+		{bonus: 1500, cap: limit(1000), want: 1000, capped: true},
+		{bonus: 10, cap: limit(0), want: 0, capped: true},
+	}
+	for _, tt := range tests {
+		got, capped := applyBonusCap(tt.bonus, tt.cap)
+		if got != tt.want || capped != tt.capped { t.Errorf("applyBonusCap(%v, %v) = %v, %v; want %v, %v", tt.bonus, tt.cap, got, capped, tt.want, tt.capped) }
+	}
+}
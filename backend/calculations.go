@@ -1,58 +1,23 @@
 package main
 
 import (
+	"fmt"
 	"math"
-	"regexp"
 	"sort"
-	"strconv"
 	"strings"
 )
 
-func parseRupiah(s string) float64 {
-	s = strings.TrimSpace(s)
-	if s == "" {
-		return 0
-	}
-	// keep digits, comma and dot
-	re := regexp.MustCompile(`[^0-9.,\-]`)
-	s = re.ReplaceAllString(s, "")
-	// normalize thousand/decimal: remove dots, replace comma with dot
-	s = strings.ReplaceAll(s, ".", "")
-	s = strings.ReplaceAll(s, ",", ".")
-	f, _ := strconv.ParseFloat(s, 64)
-	return f
-}
-
-func CalculateBonus(kpiConfigs []KpiConfig, bonusSchemes []BonusScheme, kpiIndicators []KpiIndicator, realisasiInputs map[uint]string, bonusCalculationMethod string, customCostKeywords []string) CalculationResult {
-	totalOmsetRealisasi := 0.0
-	totalOmsetTarget := 0.0
-	grandTotalPoin := 0.0
-	details := make([]KpiResultDetail, 0, len(kpiConfigs))
-
-	// Cost keywords
-	defaultCostKeywords := []string{"biaya", "cost", "spend", "ads", "iklan"}
-	costKeywords := defaultCostKeywords
-	if len(customCostKeywords) > 0 {
-		costKeywords = make([]string, 0, len(customCostKeywords))
-		for _, k := range customCostKeywords {
-			k = strings.ToLower(strings.TrimSpace(k))
-			if k != "" {
-				costKeywords = append(costKeywords, k)
-			}
-		}
-	}
-	isCostKpi := func(name string) bool {
-		n := strings.ToLower(name)
-		for _, kw := range costKeywords {
-			if strings.Contains(n, kw) { return true }
-		}
-		return false
+// derivedFormulas collects the formula of every derived KPI. An explicit Formula wins; a legacy
+// SpecialCalc ROAS row becomes "revenue / cost" using the revenue and cost KPIs of its platform.
+func derivedFormulas(kpiConfigs []KpiConfig, roleOf func(KpiConfig) string) map[uint]*Formula {
+	formulas := map[uint]*Formula{}
+	for _, k := range kpiConfigs {
+		if k.Formula == nil || strings.TrimSpace(*k.Formula) == "" { continue }
+		if f, err := ParseFormula(*k.Formula); err == nil { formulas[k.ID] = f }
 	}
 
-	// Pre-calc ROAS per platform
 	platformSet := map[string]struct{}{}
 	for _, k := range kpiConfigs { platformSet[k.Platform] = struct{}{} }
-	roasValues := map[uint]float64{}
 	for platform := range platformSet {
 		var roasKpi *KpiConfig
 		var omsetKpi *KpiConfig
@@ -61,36 +26,75 @@ func CalculateBonus(kpiConfigs []KpiConfig, bonusSchemes []BonusScheme, kpiIndic
 			k := &kpiConfigs[i]
 			if k.Platform != platform { continue }
 			if k.SpecialCalc != nil && *k.SpecialCalc == "ROAS" { roasKpi = k }
-			if strings.Contains(strings.ToLower(k.Name), "omset") { omsetKpi = k }
-			if biayaKpi == nil && isCostKpi(k.Name) { biayaKpi = k }
-		}
-		if omsetKpi == nil { // fallback: first currency non-cost non-ROAS
-			for i := range kpiConfigs {
-				k := &kpiConfigs[i]
-				if k.Platform != platform { continue }
-				if k.IsCurrency && !isCostKpi(k.Name) && (k.SpecialCalc == nil || *k.SpecialCalc != "ROAS") { omsetKpi = k; break }
+			switch roleOf(*k) {
+			case KpiRoleRevenue:
+				// prefer the KPI actually called omset when a platform has several revenue KPIs
+				if omsetKpi == nil || (!strings.Contains(strings.ToLower(omsetKpi.Name), "omset") && strings.Contains(strings.ToLower(k.Name), "omset")) { omsetKpi = k }
+			case KpiRoleCost:
+				if biayaKpi == nil { biayaKpi = k }
 			}
 		}
-		if roasKpi != nil && omsetKpi != nil && biayaKpi != nil {
-			omsetRealisasi := parseRupiah(realisasiInputs[omsetKpi.ID])
-			biayaRealisasi := parseRupiah(realisasiInputs[biayaKpi.ID])
-			calc := 0.0
-			if biayaRealisasi > 0 { calc = omsetRealisasi / biayaRealisasi }
-			roasValues[roasKpi.ID] = calc
+		if roasKpi != nil && omsetKpi != nil && biayaKpi != nil && formulas[roasKpi.ID] == nil {
+			f, err := ParseFormula(fmt.Sprintf("#%d / #%d", omsetKpi.ID, biayaKpi.ID))
+			if err == nil { formulas[roasKpi.ID] = f }
 		}
 	}
+	return formulas
+}
+
+// ValidateKpiFormulas checks that every explicit formula parses, references KPIs of the
+// same set and is free of cycles
+func ValidateKpiFormulas(kpiConfigs []KpiConfig) error {
+	formulas := map[uint]*Formula{}
+	for _, k := range kpiConfigs {
+		if k.Formula == nil || strings.TrimSpace(*k.Formula) == "" { continue }
+		f, err := ParseFormula(*k.Formula)
+		if err != nil { return fmt.Errorf("formula of %q: %w", k.Name, err) }
+		formulas[k.ID] = f
+	}
+	_, err := planFormulas(kpiConfigs, formulas)
+	return err
+}
+
+func CalculateBonus(kpiConfigs []KpiConfig, bonusSchemes []BonusScheme, kpiIndicators []KpiIndicator, realisasiInputs map[uint]string, bonusCalculationMethod string, customCostKeywords []string, settings BonusSettings) CalculationResult {
+	totalOmsetRealisasi := 0.0
+	totalOmsetTarget := 0.0
+	grandTotalPoin := 0.0
+	details := make([]KpiResultDetail, 0, len(kpiConfigs))
+
+	// Explicit roles win; rows without one fall back to the cost keyword heuristic
+	isCostKpi := costKeywordMatcher(customCostKeywords)
+	roleOf := func(k KpiConfig) string { return resolveKpiRole(k, isCostKpi) }
+
+	formulas := derivedFormulas(kpiConfigs, roleOf)
+	// Saved formulas are validated, but a legacy ROAS formula can still close a cycle and ad-hoc
+	// configurations are not validated at all. Derived KPIs then count as 0 and the plan error is
+	// reported in InputErrors, so callers reject the result instead of paying it out.
+	inputErrors := []KpiInputError{}
+	plan, err := planFormulas(kpiConfigs, formulas)
+	if err != nil {
+		plan = formulaPlan{}
+		inputErrors = append(inputErrors, KpiInputError{Name: "formulas", Error: err.Error()})
+	}
 
+	// Entered values first, then derived KPIs in dependency order. Unparsable inputs count as 0
+	// and are reported in InputErrors so callers can reject them.
+	values := map[uint]float64{}
 	for _, kpi := range kpiConfigs {
-		realisasi := 0.0
-		if kpi.SpecialCalc != nil && *kpi.SpecialCalc == "ROAS" {
-			realisasi = roasValues[kpi.ID]
-		} else {
-			val := "0"
-			if v, ok := realisasiInputs[kpi.ID]; ok { val = v }
-			if kpi.IsCurrency { realisasi = parseRupiah(val) } else { val = strings.ReplaceAll(val, ",", "."); f, _ := strconv.ParseFloat(val, 64); realisasi = f }
-		}
+		if formulas[kpi.ID] != nil { continue }
+		val := "0"
+		if v, ok := realisasiInputs[kpi.ID]; ok { val = v }
+		f, err := ParseNumber(val, kpi.IsCurrency)
+		if err != nil { inputErrors = append(inputErrors, KpiInputError{KpiID: kpi.ID, Name: kpi.Name, Input: val, Error: err.Error()}) }
+		values[kpi.ID] = f
+	}
+	plan.evaluate(values)
+
+	for _, kpi := range kpiConfigs {
+		realisasi := values[kpi.ID]
+		isDerived := formulas[kpi.ID] != nil || (kpi.SpecialCalc != nil && *kpi.SpecialCalc == "ROAS")
 
-		if kpi.IsCurrency && !isCostKpi(kpi.Name) {
+		if roleOf(kpi) == KpiRoleRevenue {
 			totalOmsetRealisasi += realisasi
 			totalOmsetTarget += kpi.Target
 		}
@@ -98,7 +102,7 @@ func CalculateBonus(kpiConfigs []KpiConfig, bonusSchemes []BonusScheme, kpiIndic
 		target := kpi.Target
 		achievementRatio := 0.0
 		if target > 0 && realisasi > 0 {
-			if kpi.SpecialCalc != nil && *kpi.SpecialCalc == "ROAS" && kpi.MinTarget != nil && realisasi < *kpi.MinTarget {
+			if isDerived && kpi.MinTarget != nil && realisasi < *kpi.MinTarget {
 				achievementRatio = 0
 			} else if kpi.Type == "higher_is_better" {
 				achievementRatio = realisasi / target
@@ -120,17 +124,19 @@ func CalculateBonus(kpiConfigs []KpiConfig, bonusSchemes []BonusScheme, kpiIndic
 	}
 
 	if bonusCalculationMethod == "NON_SALES" {
-		return CalculationResult{GrandTotalPoin: grandTotalPoin, FinalBonus: 0, ActiveMultiplier: 0, KpiIndicator: kpiIndicator, OmsetIndicator: map[string]any{"name":"N/A"}, TotalOmsetRealisasi: totalOmsetRealisasi, TotalOmsetTarget: totalOmsetTarget, Details: details}
+		return CalculationResult{GrandTotalPoin: grandTotalPoin, FinalBonus: 0, ActiveMultiplier: 0, KpiIndicator: kpiIndicator, OmsetIndicator: map[string]any{"name":"N/A"}, TotalOmsetRealisasi: totalOmsetRealisasi, TotalOmsetTarget: totalOmsetTarget, Details: details, SchemeBreakdown: []SchemeTierDetail{}, InputErrors: inputErrors}
 	}
 
-	sort.Slice(bonusSchemes, func(i, j int) bool { return bonusSchemes[i].Threshold > bonusSchemes[j].Threshold })
-	activeMultiplier := 0.0
-	omsetIndicator := map[string]any{"name": "N/A"}
+	mode := settings.SchemeMode
+	if !validSchemeModes[mode] { mode = SchemeModeStep }
 	sourceValue := totalOmsetRealisasi
 	if bonusCalculationMethod == "POINTS_BASED" { sourceValue = grandTotalPoin }
-	for _, s := range bonusSchemes {
-		if sourceValue >= s.Threshold { activeMultiplier = s.Multiplier; omsetIndicator = map[string]any{"id": s.ID, "name": s.Name, "threshold": s.Threshold, "multiplier": s.Multiplier}; break }
-	}
-	finalBonus := (grandTotalPoin * 1000) * activeMultiplier
-	return CalculationResult{GrandTotalPoin: grandTotalPoin, FinalBonus: finalBonus, ActiveMultiplier: activeMultiplier, KpiIndicator: kpiIndicator, OmsetIndicator: omsetIndicator, TotalOmsetRealisasi: totalOmsetRealisasi, TotalOmsetTarget: totalOmsetTarget, Details: details}
+	activeMultiplier, active, breakdown := evaluateSchemes(bonusSchemes, sourceValue, mode)
+	omsetIndicator := map[string]any{"name": "N/A"}
+	if active != nil { omsetIndicator = map[string]any{"id": active.ID, "name": active.Name, "threshold": active.Threshold, "multiplier": active.Multiplier} }
+	uncappedBonus, base, bonusFormula := settings.finalBonus(grandTotalPoin, activeMultiplier, totalOmsetRealisasi, totalOmsetTarget)
+	finalBonus, capped := applyBonusCap(uncappedBonus, settings.BonusCap)
+	baseSource := settings.BaseSource
+	if baseSource == "" { baseSource = BonusBaseDefault }
+	return CalculationResult{GrandTotalPoin: grandTotalPoin, FinalBonus: finalBonus, ActiveMultiplier: activeMultiplier, KpiIndicator: kpiIndicator, OmsetIndicator: omsetIndicator, TotalOmsetRealisasi: totalOmsetRealisasi, TotalOmsetTarget: totalOmsetTarget, Details: details, SchemeMode: mode, SchemeBreakdown: breakdown, UncappedBonus: uncappedBonus, BonusCapped: capped, BonusBase: base, BonusBaseSource: baseSource, BonusFormula: bonusFormula, InputErrors: inputErrors}
 }
\ No newline at end of file
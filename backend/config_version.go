@@ -0,0 +1,152 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// snapshotPayload drops row timestamps so that saving an unchanged row does not produce a new version
+func (cfg DivisionConfig) snapshotPayload() DivisionConfig {
+	out := DivisionConfig{Division: cfg.Division}
+	out.Division.CreatedAt, out.Division.UpdatedAt = time.Time{}, time.Time{}
+	for _, k := range cfg.KpiConfigs {
+		k.CreatedAt, k.UpdatedAt = time.Time{}, time.Time{}
+		out.KpiConfigs = append(out.KpiConfigs, k)
+	}
+	for _, s := range cfg.BonusSchemes {
+		s.CreatedAt, s.UpdatedAt = time.Time{}, time.Time{}
+		out.BonusSchemes = append(out.BonusSchemes, s)
+	}
+	for _, ind := range cfg.KpiIndicators {
+		ind.CreatedAt, ind.UpdatedAt = time.Time{}, time.Time{}
+		out.KpiIndicators = append(out.KpiIndicators, ind)
+	}
+	for _, g := range cfg.GradeRates {
+		g.CreatedAt, g.UpdatedAt = time.Time{}, time.Time{}
+		out.GradeRates = append(out.GradeRates, g)
+	}
+	return out
+}
+
+// snapshotConfig stores cfg as a new ConfigVersion unless it is identical to the latest one
+func snapshotConfig(tx *gorm.DB, cfg DivisionConfig) (ConfigVersion, error) {
+	b, err := json.Marshal(cfg.snapshotPayload())
+	if err != nil { return ConfigVersion{}, err }
+	sum := sha256.Sum256(b)
+	checksum := hex.EncodeToString(sum[:])
+
+	var latest ConfigVersion
+	err = tx.Where("division_id = ?", cfg.Division.ID).Order("version desc").First(&latest).Error
+	if err == nil && latest.Checksum == checksum { return latest, nil }
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) { return ConfigVersion{}, err }
+
+	version := ConfigVersion{DivisionID: cfg.Division.ID, Version: latest.Version + 1, Checksum: checksum, ConfigJSON: string(b)}
+	if err := tx.Create(&version).Error; err != nil { return ConfigVersion{}, err }
+	return version, nil
+}
+
+// snapshotDivisionConfig loads the division's current configuration and snapshots it
+func snapshotDivisionConfig(tx *gorm.DB, divisionID uint) (ConfigVersion, error) {
+	cfg, err := loadDivisionConfig(tx, divisionID)
+	if err != nil { return ConfigVersion{}, err }
+	return snapshotConfig(tx, cfg)
+}
+
+// configChangeHook snapshots every division touched by a write to a configuration row
+func configChangeHook[T any](divisionOf func(*T) uint) writeHook[T] {
+	return func(tx *gorm.DB, before, after *T) error {
+		ids := map[uint]bool{}
+		if before != nil { ids[divisionOf(before)] = true }
+		if after != nil { ids[divisionOf(after)] = true }
+		for id := range ids {
+			if id == 0 { continue }
+			if _, err := snapshotDivisionConfig(tx, id); err != nil && !errors.Is(err, gorm.ErrRecordNotFound) { return err }
+		}
+		return nil
+	}
+}
+
+// ensureConfigSnapshots gives every division a baseline version at startup
+func ensureConfigSnapshots() {
+	var divisions []Division
+	if err := db.Find(&divisions).Error; err != nil { log.Printf("Failed to load divisions for config snapshot: %v", err); return }
+	for _, d := range divisions {
+		if _, err := snapshotDivisionConfig(db, d.ID); err != nil {
+			log.Printf("Failed to snapshot configuration for division %s: %v", d.Name, err)
+		}
+	}
+}
+
+// ConfigVersionResponse exposes a snapshot with its configuration decoded
+type ConfigVersionResponse struct {
+	ConfigVersion
+	Config DivisionConfig `json:"config"`
+}
+
+func toConfigVersionResponse(v ConfigVersion) (ConfigVersionResponse, error) {
+	resp := ConfigVersionResponse{ConfigVersion: v}
+	err := json.Unmarshal([]byte(v.ConfigJSON), &resp.Config)
+	return resp, err
+}
+
+// historyConfig returns the configuration a history entry was calculated with, falling back
+// to the division's current configuration for entries saved before snapshots existed
+func historyConfig(entry HistoryEntry) (DivisionConfig, error) {
+	if entry.ConfigVersionID == nil { return loadDivisionConfig(db, entry.DivisionID) }
+	var v ConfigVersion
+	if err := db.First(&v, *entry.ConfigVersionID).Error; err != nil { return DivisionConfig{}, err }
+	resp, err := toConfigVersionResponse(v)
+	return resp.Config, err
+}
+
+func registerConfigVersionRoutes(r *gin.RouterGroup) {
+	// Snapshots carry targets, schemes and bonus formulas, so employees cannot read them and
+	// managers only read their own division's
+	readers := requireRole(RoleAdmin, RoleHR, RoleManager)
+
+	// GET /divisions/:id/config-versions lists snapshot metadata, newest first
+	r.GET("/divisions/:id/config-versions", readers, func(c *gin.Context) {
+		id, ok := parseID(c)
+		if !ok { return }
+		if !currentUser(c).canReadDivision(id) { c.JSON(http.StatusForbidden, gin.H{"error": "not allowed to read this division's configuration"}); return }
+		var list []ConfigVersion
+		if err := db.Where("division_id = ?", id).Order("version desc").Find(&list).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()}); return
+		}
+		c.JSON(http.StatusOK, list)
+	})
+
+	r.GET("/config-versions/:id", readers, func(c *gin.Context) {
+		id, ok := parseID(c)
+		if !ok { return }
+		var v ConfigVersion
+		if err := db.First(&v, id).Error; err != nil { respondLookupError(c, "config version", err); return }
+		if !currentUser(c).canReadDivision(v.DivisionID) { c.JSON(http.StatusNotFound, gin.H{"error": "config version not found"}); return }
+		resp, err := toConfigVersionResponse(v)
+		if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()}); return }
+		c.JSON(http.StatusOK, resp)
+	})
+
+	// GET /history/:id/config returns the exact configuration a past calculation used
+	r.GET("/history/:id/config", func(c *gin.Context) {
+		id, ok := parseID(c)
+		if !ok { return }
+		var entry HistoryEntry
+		if err := db.First(&entry, id).Error; err != nil { respondLookupError(c, "history entry", err); return }
+		if !currentUser(c).canReadHistory(entry) { c.JSON(http.StatusNotFound, gin.H{"error": "history entry not found"}); return }
+		if entry.ConfigVersionID == nil { c.JSON(http.StatusNotFound, gin.H{"error": "no configuration snapshot recorded for this history entry"}); return }
+		var v ConfigVersion
+		if err := db.First(&v, *entry.ConfigVersionID).Error; err != nil { respondLookupError(c, "config version", err); return }
+		resp, err := toConfigVersionResponse(v)
+		if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()}); return }
+		c.JSON(http.StatusOK, resp)
+	})
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+var (
+	validBonusMethods = map[string]bool{"OMSET_BASED": true, "POINTS_BASED": true, "NON_SALES": true}
+	validKpiTypes     = map[string]bool{"higher_is_better": true, "lower_is_better": true}
+	validCappings     = map[string]bool{"uncapped": true, "capped": true}
+)
+
+// parseID reads the :id route parameter
+func parseID(c *gin.Context) (uint, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil || id == 0 { c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"}); return 0, false }
+	return uint(id), true
+}
+
+// respondLookupError maps a failed First() to 404 or 500
+func respondLookupError(c *gin.Context, entity string, err error) {
+	if errors.Is(err, gorm.ErrRecordNotFound) { c.JSON(http.StatusNotFound, gin.H{"error": entity + " not found"}); return }
+	c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+}
+
+func requireDivision(divisionID uint) error {
+	if divisionID == 0 { return errors.New("divisionId is required") }
+	var cnt int64
+	if err := db.Model(&Division{}).Where("id = ?", divisionID).Count(&cnt).Error; err != nil { return err }
+	if cnt == 0 { return fmt.Errorf("division %d not found", divisionID) }
+	return nil
+}
+
+// Validation and defaults per model; id is 0 on create
+
+func prepareDivision(id uint, d *Division) error {
+	d.Name = strings.TrimSpace(d.Name)
+	if d.Name == "" { return errors.New("name is required") }
+	if d.BonusCalculationMethod == "" { d.BonusCalculationMethod = "OMSET_BASED" }
+	if !validBonusMethods[d.BonusCalculationMethod] { return fmt.Errorf("invalid bonusCalculationMethod %q", d.BonusCalculationMethod) }
+	if d.SchemeMode == "" { d.SchemeMode = SchemeModeStep }
+	if !validSchemeModes[d.SchemeMode] { return fmt.Errorf("invalid schemeMode %q", d.SchemeMode) }
+	if d.BonusCap != nil && *d.BonusCap < 0 { return errors.New("bonusCap must not be negative") }
+	if d.BonusBaseRate != nil && *d.BonusBaseRate < 0 { return errors.New("bonusBaseRate must not be negative") }
+	if d.BonusFormula != nil && strings.TrimSpace(*d.BonusFormula) == "" { d.BonusFormula = nil }
+	if d.BonusFormula != nil {
+		if _, err := ParseBonusFormula(*d.BonusFormula); err != nil { return err }
+	}
+	var cnt int64
+	if err := db.Model(&Division{}).Where("name = ? AND id <> ?", d.Name, id).Count(&cnt).Error; err != nil { return err }
+	if cnt > 0 { return fmt.Errorf("division %q already exists", d.Name) }
+	return nil
+}
+
+func prepareEmployee(id uint, e *Employee) error {
+	e.Name = strings.TrimSpace(e.Name)
+	if e.Name == "" { return errors.New("name is required") }
+	e.Grade = strings.TrimSpace(e.Grade)
+	return requireDivision(e.DivisionID)
+}
+
+func prepareKpiConfig(id uint, k *KpiConfig) error {
+	if err := prepareKpiConfigFields(k); err != nil { return err }
+
+	// Codes and formulas are checked against the division's KPI set as it would look after this write
+	var siblings []KpiConfig
+	if err := db.Where("division_id = ? AND id <> ?", k.DivisionID, id).Find(&siblings).Error; err != nil { return err }
+	for _, other := range siblings {
+		if k.Code != "" && other.Code == k.Code { return fmt.Errorf("code %q is already used by %q", k.Code, other.Name) }
+	}
+	candidate := *k
+	candidate.ID = id
+	if id == 0 { candidate.ID = ^uint(0) } // placeholder id for a KPI that does not exist yet
+	return ValidateKpiFormulas(append(siblings, candidate))
+}
+
+// prepareKpiConfigFields normalises and checks the fields of one KPI on their own, without
+// looking at the other KPIs of its division
+func prepareKpiConfigFields(k *KpiConfig) error {
+	k.Name = strings.TrimSpace(k.Name)
+	if k.Name == "" { return errors.New("name is required") }
+	if k.PointCapping == "" { k.PointCapping = "uncapped" }
+	if k.Type == "" { k.Type = "higher_is_better" }
+	if !validKpiTypes[k.Type] { return fmt.Errorf("invalid type %q", k.Type) }
+	if !validCappings[k.PointCapping] { return fmt.Errorf("invalid pointCapping %q", k.PointCapping) }
+	if k.SpecialCalc != nil && *k.SpecialCalc == "" { k.SpecialCalc = nil }
+	if k.SpecialCalc != nil && *k.SpecialCalc != "ROAS" { return fmt.Errorf("invalid specialCalc %q", *k.SpecialCalc) }
+	if k.Bobot < 0 { return errors.New("bobot must not be negative") }
+	if k.Target < 0 { return errors.New("target must not be negative") }
+	if err := requireDivision(k.DivisionID); err != nil { return err }
+	k.Code = strings.TrimSpace(k.Code)
+	if k.Code != "" && !kpiCodePattern.MatchString(k.Code) { return fmt.Errorf("invalid code %q: use letters, digits and underscores", k.Code) }
+	if k.Formula != nil && strings.TrimSpace(*k.Formula) == "" { k.Formula = nil }
+	if k.Role == "" {
+		var d Division
+		if err := db.First(&d, k.DivisionID).Error; err != nil { return err }
+		k.Role = inferKpiRole(*k, costKeywordMatcher(DivisionConfig{Division: d}.CostKeywords()))
+	}
+	if !validKpiRoles[k.Role] { return fmt.Errorf("invalid role %q", k.Role) }
+	return nil
+}
+
+// kpiReferencedBy returns the names of KPIs whose formula refers to the given KPI
+func kpiReferencedBy(kpi KpiConfig) ([]string, error) {
+	var siblings []KpiConfig
+	if err := db.Where("division_id = ? AND id <> ? AND formula IS NOT NULL", kpi.DivisionID, kpi.ID).Find(&siblings).Error; err != nil { return nil, err }
+	var names []string
+	for _, other := range siblings {
+		f, err := ParseFormula(*other.Formula)
+		if err != nil { continue }
+		for _, ref := range f.Refs() {
+			if ref == fmt.Sprintf("#%d", kpi.ID) || (kpi.Code != "" && ref == kpi.Code) { names = append(names, other.Name); break }
+		}
+	}
+	return names, nil
+}
+
+func prepareBonusScheme(id uint, s *BonusScheme) error {
+	s.Name = strings.TrimSpace(s.Name)
+	if s.Name == "" { return errors.New("name is required") }
+	if s.Multiplier < 0 { return errors.New("multiplier must not be negative") }
+	return requireDivision(s.DivisionID)
+}
+
+func prepareGradeRate(id uint, g *GradeRate) error {
+	g.Grade = strings.TrimSpace(g.Grade)
+	if g.Grade == "" { return errors.New("grade is required") }
+	if g.BaseRate < 0 { return errors.New("baseRate must not be negative") }
+	if err := requireDivision(g.DivisionID); err != nil { return err }
+	var cnt int64
+	if err := db.Model(&GradeRate{}).Where("division_id = ? AND LOWER(grade) = LOWER(?) AND id <> ?", g.DivisionID, g.Grade, id).Count(&cnt).Error; err != nil { return err }
+	if cnt > 0 { return fmt.Errorf("grade %q already has a rate in this division", g.Grade) }
+	return nil
+}
+
+func prepareKpiIndicator(id uint, ind *KpiIndicator) error {
+	ind.Name = strings.TrimSpace(ind.Name)
+	if ind.Name == "" { return errors.New("name is required") }
+	return requireDivision(ind.DivisionID)
+}
+
+// listRecords returns the rows of T in the divisions the caller may read, optionally filtered by
+// ?division_id. Master data carries targets, schemes and rates, so employees see none of it.
+func listRecords[T any](c *gin.Context, byDivision bool) {
+	var list []T
+	column := "id"
+	if byDivision { column = "division_id" }
+	q := scopeDivisions(db, currentUser(c), column)
+	if did := c.Query("division_id"); byDivision && did != "" { q = q.Where("division_id = ?", did) }
+	if err := q.Find(&list).Error; err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()}); return }
+	c.JSON(http.StatusOK, list)
+}
+
+// writeHook runs inside the write transaction; before is nil on create and after is nil on delete
+type writeHook[T any] func(tx *gorm.DB, before, after *T) error
+
+func createRecord[T any](c *gin.Context, prepare func(uint, *T) error, hook writeHook[T]) {
+	var payload T
+	if err := c.ShouldBindJSON(&payload); err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()}); return }
+	if err := prepare(0, &payload); err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()}); return }
+	err := db.WithContext(c).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&payload).Error; err != nil { return err }
+		if hook != nil { return hook(tx, nil, &payload) }
+		return nil
+	})
+	if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()}); return }
+	c.JSON(http.StatusCreated, payload)
+}
+
+// updateRecord handles PUT (replace every editable field) and PATCH (merge the body into the stored row)
+func updateRecord[T any](c *gin.Context, entity string, partial bool, prepare func(uint, *T) error, hook writeHook[T]) {
+	id, ok := parseID(c)
+	if !ok { return }
+	var existing T
+	if err := db.First(&existing, id).Error; err != nil { respondLookupError(c, entity, err); return }
+	var payload T
+	if partial { payload = existing }
+	if err := c.ShouldBindJSON(&payload); err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()}); return }
+	if err := prepare(id, &payload); err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()}); return }
+	var updated T
+	err := db.WithContext(c).Transaction(func(tx *gorm.DB) error {
+		before := existing
+		if err := tx.Model(&existing).Select("*").Omit("id", "created_at").Updates(&payload).Error; err != nil { return err }
+		if err := tx.First(&updated, id).Error; err != nil { return err }
+		if hook != nil { return hook(tx, &before, &updated) }
+		return nil
+	})
+	if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()}); return }
+	c.JSON(http.StatusOK, updated)
+}
+
+// deleteRecord removes one row of T; the hook runs in the same transaction and may cascade
+func deleteRecord[T any](c *gin.Context, entity string, hook writeHook[T]) {
+	id, ok := parseID(c)
+	if !ok { return }
+	var existing T
+	if err := db.First(&existing, id).Error; err != nil { respondLookupError(c, entity, err); return }
+	err := db.WithContext(c).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&existing).Error; err != nil { return err }
+		if hook != nil { return hook(tx, &existing, nil) }
+		return nil
+	})
+	if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()}); return }
+	c.Status(http.StatusNoContent)
+}
+
+// registerCrudRoutes exposes master data to the readers of its division; writes are admin-only
+func registerCrudRoutes(r *gin.RouterGroup) {
+	admin := requireRole(RoleAdmin)
+	divisionHook := chainHooks(auditHook[Division]("division"), configChangeHook(func(d *Division) uint { return d.ID }))
+	employeeHook := auditHook[Employee]("employee")
+	kpiHook := chainHooks(auditHook[KpiConfig]("kpi"), configChangeHook(func(k *KpiConfig) uint { return k.DivisionID }))
+	schemeHook := chainHooks(auditHook[BonusScheme]("scheme"), configChangeHook(func(s *BonusScheme) uint { return s.DivisionID }))
+	indicatorHook := chainHooks(auditHook[KpiIndicator]("indicator"), configChangeHook(func(ind *KpiIndicator) uint { return ind.DivisionID }))
+	gradeRateHook := chainHooks(auditHook[GradeRate]("grade_rate"), configChangeHook(func(g *GradeRate) uint { return g.DivisionID }))
+
+	r.GET("/divisions", func(c *gin.Context) { listRecords[Division](c, false) })
+	r.POST("/divisions", admin, func(c *gin.Context) { createRecord(c, prepareDivision, divisionHook) })
+	r.PUT("/divisions/:id", admin, func(c *gin.Context) { updateRecord(c, "division", false, prepareDivision, divisionHook) })
+	r.PATCH("/divisions/:id", admin, func(c *gin.Context) { updateRecord(c, "division", true, prepareDivision, divisionHook) })
+	// Deleting a division removes its master data. History is payroll evidence, so a division
+	// that still has history is refused with 409 unless ?force=true is given, which also deletes
+	// the history and the configuration snapshots it was pinned to. Even then approved or paid
+	// history, or history in a closed period, is locked (423): only draft, submitted and rejected
+	// entries can go.
+	r.DELETE("/divisions/:id", admin, func(c *gin.Context) {
+		id, ok := parseID(c)
+		if !ok { return }
+		var cnt int64
+		if err := db.Model(&HistoryEntry{}).Where("division_id = ?", id).Count(&cnt).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()}); return
+		}
+		if cnt > 0 && c.Query("force") != "true" {
+			c.JSON(http.StatusConflict, gin.H{"error": "division has history entries; pass force=true to delete them too", "historyCount": cnt}); return
+		}
+		var locked int64
+		err := db.Model(&HistoryEntry{}).Where("division_id = ? AND (period_id IN (SELECT id FROM periods WHERE status = ?) OR period_id IN (SELECT period_id FROM period_closures WHERE division_id = ? AND status = ?))",
+			id, PeriodClosed, id, PeriodClosed).Count(&locked).Error
+		if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()}); return }
+		if locked > 0 { c.JSON(http.StatusLocked, gin.H{"error": "division has history in closed periods; reopen them before deleting it", "historyCount": locked}); return }
+		if err := db.Model(&HistoryEntry{}).Where("division_id = ? AND status IN ?", id, lockedStatuses).Count(&locked).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()}); return
+		}
+		if locked > 0 { c.JSON(http.StatusLocked, gin.H{"error": "division has approved or paid history, which can no longer be deleted", "historyCount": locked}); return }
+		deleteRecord(c, "division", func(tx *gorm.DB, before, _ *Division) error {
+			if err := recordAudit(tx, AuditDelete, "division", before, nil); err != nil { return err }
+			if err := tx.Where("history_id IN (SELECT id FROM history_entries WHERE division_id = ?)", before.ID).Delete(&HistoryKpiResult{}).Error; err != nil { return err }
+			if err := deleteAudited[HistoryEntry](tx, "history", "division_id = ?", before.ID); err != nil { return err }
+			if err := deleteAudited[Employee](tx, "employee", "division_id = ?", before.ID); err != nil { return err }
+			if err := deleteAudited[KpiConfig](tx, "kpi", "division_id = ?", before.ID); err != nil { return err }
+			if err := deleteAudited[BonusScheme](tx, "scheme", "division_id = ?", before.ID); err != nil { return err }
+			if err := deleteAudited[KpiIndicator](tx, "indicator", "division_id = ?", before.ID); err != nil { return err }
+			if err := deleteAudited[GradeRate](tx, "grade_rate", "division_id = ?", before.ID); err != nil { return err }
+			if err := deleteAudited[PdfTemplate](tx, "pdf_template", "division_id = ?", before.ID); err != nil { return err }
+			if err := deleteAudited[PeriodClosure](tx, "period_closure", "division_id = ?", before.ID); err != nil { return err }
+			if err := deleteAudited[DivisionBudget](tx, "division_budget", "division_id = ?", before.ID); err != nil { return err }
+			if err := deleteAudited[PayrollRun](tx, "payroll_run", "division_id = ?", before.ID); err != nil { return err }
+			return tx.Where("division_id = ?", before.ID).Delete(&ConfigVersion{}).Error
+		})
+	})
+
+	// Employee history keeps its employeeName snapshot, so it survives the employee being deleted
+	r.GET("/employees", func(c *gin.Context) { listRecords[Employee](c, true) })
+	r.POST("/employees", admin, func(c *gin.Context) { createRecord(c, prepareEmployee, employeeHook) })
+	r.PUT("/employees/:id", admin, func(c *gin.Context) { updateRecord(c, "employee", false, prepareEmployee, employeeHook) })
+	r.PATCH("/employees/:id", admin, func(c *gin.Context) { updateRecord(c, "employee", true, prepareEmployee, employeeHook) })
+	r.DELETE("/employees/:id", admin, func(c *gin.Context) { deleteRecord(c, "employee", employeeHook) })
+
+	// Every write to KPIs, schemes and indicators produces a new ConfigVersion for the division
+	r.GET("/kpis", func(c *gin.Context) { listRecords[KpiConfig](c, true) })
+	r.POST("/kpis", admin, func(c *gin.Context) { createRecord(c, prepareKpiConfig, kpiHook) })
+	r.PUT("/kpis/:id", admin, func(c *gin.Context) { updateRecord(c, "kpi", false, prepareKpiConfig, kpiHook) })
+	r.PATCH("/kpis/:id", admin, func(c *gin.Context) { updateRecord(c, "kpi", true, prepareKpiConfig, kpiHook) })
+	// A KPI used by another KPI's formula cannot be deleted until the formula is changed
+	r.DELETE("/kpis/:id", admin, func(c *gin.Context) {
+		id, ok := parseID(c)
+		if !ok { return }
+		var kpi KpiConfig
+		if err := db.First(&kpi, id).Error; err != nil { respondLookupError(c, "kpi", err); return }
+		users, err := kpiReferencedBy(kpi)
+		if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()}); return }
+		if len(users) > 0 { c.JSON(http.StatusConflict, gin.H{"error": "kpi is referenced by other formulas", "referencedBy": users}); return }
+		deleteRecord(c, "kpi", kpiHook)
+	})
+
+	r.GET("/schemes", func(c *gin.Context) { listRecords[BonusScheme](c, true) })
+	r.POST("/schemes", admin, func(c *gin.Context) { createRecord(c, prepareBonusScheme, schemeHook) })
+	r.PUT("/schemes/:id", admin, func(c *gin.Context) { updateRecord(c, "scheme", false, prepareBonusScheme, schemeHook) })
+	r.PATCH("/schemes/:id", admin, func(c *gin.Context) { updateRecord(c, "scheme", true, prepareBonusScheme, schemeHook) })
+	r.DELETE("/schemes/:id", admin, func(c *gin.Context) { deleteRecord(c, "scheme", schemeHook) })
+
+	r.GET("/indicators", func(c *gin.Context) { listRecords[KpiIndicator](c, true) })
+	r.POST("/indicators", admin, func(c *gin.Context) { createRecord(c, prepareKpiIndicator, indicatorHook) })
+	r.PUT("/indicators/:id", admin, func(c *gin.Context) { updateRecord(c, "indicator", false, prepareKpiIndicator, indicatorHook) })
+	r.PATCH("/indicators/:id", admin, func(c *gin.Context) { updateRecord(c, "indicator", true, prepareKpiIndicator, indicatorHook) })
+	r.DELETE("/indicators/:id", admin, func(c *gin.Context) { deleteRecord(c, "indicator", indicatorHook) })
+
+	// Grade rates override the division's bonusBaseRate for employees with a matching grade
+	r.GET("/grade-rates", func(c *gin.Context) { listRecords[GradeRate](c, true) })
+	r.POST("/grade-rates", admin, func(c *gin.Context) { createRecord(c, prepareGradeRate, gradeRateHook) })
+	r.PUT("/grade-rates/:id", admin, func(c *gin.Context) { updateRecord(c, "grade rate", false, prepareGradeRate, gradeRateHook) })
+	r.PATCH("/grade-rates/:id", admin, func(c *gin.Context) { updateRecord(c, "grade rate", true, prepareGradeRate, gradeRateHook) })
+	r.DELETE("/grade-rates/:id", admin, func(c *gin.Context) { deleteRecord(c, "grade rate", gradeRateHook) })
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// DivisionConfig bundles everything CalculateBonus needs for one division
+type DivisionConfig struct {
+	Division      Division       `json:"division"`
+	KpiConfigs    []KpiConfig    `json:"kpiConfigs"`
+	BonusSchemes  []BonusScheme  `json:"bonusSchemes"`
+	KpiIndicators []KpiIndicator `json:"kpiIndicators"`
+	GradeRates    []GradeRate    `json:"gradeRates"`
+}
+
+// loadDivisionConfig reads the current KPI, scheme and indicator rows of a division
+func loadDivisionConfig(tx *gorm.DB, divisionID uint) (DivisionConfig, error) {
+	var cfg DivisionConfig
+	if err := tx.First(&cfg.Division, divisionID).Error; err != nil { return cfg, err }
+	if err := tx.Where("division_id = ?", divisionID).Order("id").Find(&cfg.KpiConfigs).Error; err != nil { return cfg, err }
+	if err := tx.Where("division_id = ?", divisionID).Order("id").Find(&cfg.BonusSchemes).Error; err != nil { return cfg, err }
+	if err := tx.Where("division_id = ?", divisionID).Order("id").Find(&cfg.KpiIndicators).Error; err != nil { return cfg, err }
+	if err := tx.Where("division_id = ?", divisionID).Order("id").Find(&cfg.GradeRates).Error; err != nil { return cfg, err }
+	return cfg, nil
+}
+
+// splitCSV splits a comma-separated setting, dropping blanks
+func splitCSV(v string) []string {
+	out := []string{}
+	for _, k := range strings.Split(v, ",") {
+		if k = strings.TrimSpace(k); k != "" { out = append(out, k) }
+	}
+	return out
+}
+
+// CostKeywords splits the division's comma-separated cost keywords
+func (cfg DivisionConfig) CostKeywords() []string { return splitCSV(cfg.Division.CostKeywords) }
+
+// BonusSettings returns the division's bonus settings for an employee of the given grade
+func (cfg DivisionConfig) BonusSettings(grade string) BonusSettings {
+	rate, source := resolveBonusBase(cfg.Division.BonusBaseRate, cfg.GradeRates, grade)
+	return BonusSettings{SchemeMode: cfg.Division.SchemeMode, BonusCap: cfg.Division.BonusCap, BaseRate: rate, BaseSource: source, Formula: cfg.Division.BonusFormula}
+}
+
+// Calculate runs CalculateBonus on copies of the config slices, since the engine sorts them in place
+func (cfg DivisionConfig) Calculate(grade string, realisasiInputs map[uint]string) CalculationResult {
+	kpis := append([]KpiConfig(nil), cfg.KpiConfigs...)
+	schemes := append([]BonusScheme(nil), cfg.BonusSchemes...)
+	indicators := append([]KpiIndicator(nil), cfg.KpiIndicators...)
+	return CalculateBonus(kpis, schemes, indicators, realisasiInputs, cfg.Division.BonusCalculationMethod, cfg.CostKeywords(), cfg.BonusSettings(grade))
+}
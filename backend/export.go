@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xuri/excelize/v2"
+)
+
+const xlsxContentType = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+
+// exportSheet writes rows to a new sheet through a stream writer, so large periods are not
+// held as cell objects in memory
+func exportSheet(f *excelize.File, name string, header []any, rows [][]any) error {
+	if _, err := f.NewSheet(name); err != nil { return err }
+	sw, err := f.NewStreamWriter(name)
+	if err != nil { return err }
+	if err := sw.SetRow("A1", header); err != nil { return err }
+	for i, row := range rows {
+		cell, _ := excelize.CoordinatesToCellName(1, i+2)
+		if err := sw.SetRow(cell, row); err != nil { return err }
+	}
+	return sw.Flush()
+}
+
+func optionalFloat(v *float64) any {
+	if v == nil { return "" }
+	return *v
+}
+
+// historyWorkbook builds the payroll workbook for a set of history entries: totals per entry,
+// with the bonus as calculated next to the one paid after payroll runs, per-KPI detail scores
+// and every configuration version the entries were calculated with
+func historyWorkbook(entries []HistoryEntry) (*excelize.File, error) {
+	var runIDs []uint
+	for _, e := range entries {
+		if e.PayrollRunID != nil { runIDs = append(runIDs, *e.PayrollRunID) }
+	}
+	policies := map[uint]string{}
+	if len(runIDs) > 0 {
+		var runs []PayrollRun
+		if err := db.Where("id IN ?", runIDs).Find(&runs).Error; err != nil { return nil, err }
+		for _, run := range runs { policies[run.ID] = run.Policy }
+	}
+	configs := map[string]DivisionConfig{}
+	var configOrder []string
+	configKey := func(e HistoryEntry) string {
+		if e.ConfigVersionID == nil { return fmt.Sprintf("current-%d", e.DivisionID) }
+		return fmt.Sprintf("v%d", *e.ConfigVersionID)
+	}
+
+	var totals, details [][]any
+	for _, e := range entries {
+		key := configKey(e)
+		cfg, ok := configs[key]
+		if !ok {
+			var err error
+			if cfg, err = historyConfig(e); err != nil { return nil, err }
+			configs[key] = cfg
+			configOrder = append(configOrder, key)
+		}
+		var res CalculationResult
+		if e.ResultsJSON != "" { _ = json.Unmarshal([]byte(e.ResultsJSON), &res) }
+		version := ""
+		if e.ConfigVersionID != nil { version = fmt.Sprint(*e.ConfigVersionID) }
+		run, policy := "", ""
+		if e.PayrollRunID != nil { run, policy = fmt.Sprint(*e.PayrollRunID), policies[*e.PayrollRunID] }
+		totals = append(totals, []any{
+			e.ID, e.EmployeeID, e.EmployeeName, cfg.Division.Name, e.PeriodMonth, e.PeriodYear, e.Status,
+			e.TotalPoints, fmt.Sprint(res.KpiIndicator["name"]), fmt.Sprint(res.OmsetIndicator["name"]), res.ActiveMultiplier,
+			res.TotalOmsetRealisasi, res.TotalOmsetTarget, res.BonusBase, e.Bonus, paidBonus(e), run, policy, version, e.Date.Format("2006-01-02"),
+		})
+
+		kpis := map[uint]KpiConfig{}
+		for _, k := range cfg.KpiConfigs { kpis[k.ID] = k }
+		for _, d := range res.Details {
+			k := kpis[d.ID]
+			details = append(details, []any{e.ID, e.EmployeeID, e.EmployeeName, e.PeriodMonth, e.PeriodYear, d.ID, k.Platform, k.Name, k.Bobot, k.Target, d.Realisasi, d.Score, d.Poin})
+		}
+	}
+
+	var config [][]any
+	for _, key := range configOrder {
+		cfg := configs[key]
+		version := "current"
+		if strings.HasPrefix(key, "v") { version = key[1:] }
+		d := cfg.Division
+		config = append(config,
+			[]any{"Divisi", d.Name, "Versi", version, "Metode", d.BonusCalculationMethod, "Skema", d.SchemeMode, "Basis Bonus", optionalFloat(d.BonusBaseRate), "Batas Bonus", optionalFloat(d.BonusCap)},
+			[]any{"KPI", "ID", "Platform", "Nama", "Kode", "Role", "Bobot", "Target", "Min Target", "Tipe", "Mata Uang", "Formula"},
+		)
+		for _, k := range cfg.KpiConfigs {
+			formula := ""
+			if k.Formula != nil { formula = *k.Formula } else if k.SpecialCalc != nil { formula = *k.SpecialCalc }
+			config = append(config, []any{"KPI", k.ID, k.Platform, k.Name, k.Code, k.Role, k.Bobot, k.Target, optionalFloat(k.MinTarget), k.Type, k.IsCurrency, formula})
+		}
+		for _, s := range cfg.BonusSchemes { config = append(config, []any{"Skema", s.ID, "", s.Name, "", "", "", s.Threshold, "", "", "", fmt.Sprintf("x%v", s.Multiplier)}) }
+		for _, ind := range cfg.KpiIndicators { config = append(config, []any{"Indikator", ind.ID, "", ind.Name, "", "", "", ind.Threshold}) }
+		for _, g := range cfg.GradeRates { config = append(config, []any{"Grade", g.ID, "", g.Grade, "", "", "", g.BaseRate}) }
+		config = append(config, []any{})
+	}
+
+	f := excelize.NewFile()
+	err := exportSheet(f, "Ringkasan", []any{"History ID", "Karyawan ID", "Karyawan", "Divisi", "Bulan", "Tahun", "Status", "Total Poin", "Indikator KPI", "Indikator Omset", "Multiplier", "Omset Realisasi", "Omset Target", "Basis Bonus", "Bonus Terhitung", "Bonus Final", "Payroll Run", "Kebijakan Payroll", "Versi Konfigurasi", "Tanggal"}, totals)
+	if err == nil { err = exportSheet(f, "Detail KPI", []any{"History ID", "Karyawan ID", "Karyawan", "Bulan", "Tahun", "KPI ID", "Platform", "KPI", "Bobot (%)", "Target", "Realisasi", "Score (%)", "Poin"}, details) }
+	if err == nil { err = exportSheet(f, "Konfigurasi", []any{"Jenis", "ID", "Platform", "Nama", "Kode", "Role", "Bobot", "Target/Ambang", "Min Target", "Tipe", "Mata Uang", "Formula/Multiplier"}, config) }
+	if err != nil { f.Close(); return nil, err }
+	_ = f.DeleteSheet("Sheet1")
+	f.SetActiveSheet(0)
+	return f, nil
+}
+
+// writeWorkbook streams a workbook as an attachment
+func writeWorkbook(c *gin.Context, f *excelize.File, filename string) {
+	defer f.Close()
+	c.Header("Content-Type", xlsxContentType)
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", strings.ReplaceAll(filename, `"`, "")))
+	c.Status(http.StatusOK)
+	_ = f.Write(c.Writer)
+}
+
+func registerExportRoutes(r *gin.RouterGroup) {
+	// GET /divisions/:id/export.xlsx?period_month=&period_year=&quarter=&from=&to=&status= streams
+	// the payroll workbook of a division and period: current revisions only, limited to the
+	// history the caller may read
+	r.GET("/divisions/:id/export.xlsx", func(c *gin.Context) {
+		id, ok := parseID(c)
+		if !ok { return }
+		var division Division
+		if err := db.First(&division, id).Error; err != nil { respondLookupError(c, "division", err); return }
+		q := scopeHistory(db.Model(&HistoryEntry{}), currentUser(c)).Where("division_id = ? AND is_current = ?", id, true)
+		name := "Payroll " + division.Name
+		q, ok = filterHistoryPeriod(c, q)
+		if !ok { return }
+		for _, param := range []string{"period_month", "period_year", "from", "to"} {
+			if v := c.Query(param); v != "" { name += " " + v }
+		}
+		if v := c.Query("status"); v != "" { q = q.Where("status = ?", v) }
+		var entries []HistoryEntry
+		if err := q.Order("employee_name, id").Find(&entries).Error; err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()}); return }
+		f, err := historyWorkbook(entries)
+		if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()}); return }
+		writeWorkbook(c, f, name+".xlsx")
+	})
+
+	// GET /history/:id/export.xlsx is the same workbook for a single entry
+	r.GET("/history/:id/export.xlsx", func(c *gin.Context) {
+		id, ok := parseID(c)
+		if !ok { return }
+		var entry HistoryEntry
+		if err := db.First(&entry, id).Error; err != nil { respondLookupError(c, "history entry", err); return }
+		if !currentUser(c).canReadHistory(entry) { c.JSON(http.StatusNotFound, gin.H{"error": "history entry not found"}); return }
+		f, err := historyWorkbook([]HistoryEntry{entry})
+		if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()}); return }
+		writeWorkbook(c, f, fmt.Sprintf("Laporan Insentif - %s %s %d.xlsx", entry.EmployeeName, entry.PeriodMonth, entry.PeriodYear))
+	})
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHistoryWorkbookPaidBonus(t *testing.T) {
+	useTestDB(t)
+	run := PayrollRun{DivisionID: 1, PeriodID: 1, Policy: BudgetPolicyScale}
+	if err := db.Create(&run).Error; err != nil { t.Fatal(err) }
+	adjusted := 600.0
+	entries := []HistoryEntry{
+		{DivisionID: 1, EmployeeID: 101, EmployeeName: "Budi Santoso", Date: time.Now(), PeriodMonth: "Januari", PeriodYear: 2024, Bonus: 1000, AdjustedBonus: &adjusted, PayrollRunID: &run.ID, Status: StatusApproved},
+		{DivisionID: 1, EmployeeID: 102, EmployeeName: "Citra Lestari", Date: time.Now(), PeriodMonth: "Januari", PeriodYear: 2024, Bonus: 800, Status: StatusDraft},
+	}
+	f, err := historyWorkbook(entries)
+	if err != nil { t.Fatal(err) }
+	defer f.Close()
+	rows, err := f.GetRows("Ringkasan")
+	if err != nil { t.Fatal(err) }
+	column := map[string]int{}
+	for i, name := range rows[0] { column[name] = i }
+
+	tests := []struct {
+		name   string
+		row    int
+		header string
+		want   string
+	}{
+		{name: "adjusted bonus is paid", row: 1, header: "Bonus Final", want: "600"},
+		{name: "raw bonus kept", row: 1, header: "Bonus Terhitung", want: "1000"},
+		{name: "payroll run", row: 1, header: "Payroll Run", want: "1"},
+		{name: "payroll policy", row: 1, header: "Kebijakan Payroll", want: BudgetPolicyScale},
+		{name: "raw bonus before a run", row: 2, header: "Bonus Final", want: "800"},
+		{name: "no run", row: 2, header: "Payroll Run", want: ""},
+	}
+	for _, tt := range tests {
+		i, ok := column[tt.header]
+		if !ok { t.Errorf("%s: no %q column in %v", tt.name, tt.header, rows[0]); continue }
+		got := ""
+		if i < len(rows[tt.row]) { got = rows[tt.row][i] }
+		if got != tt.want { t.Errorf("%s: %s = %q, want %q", tt.name, tt.header, got, tt.want) }
+	}
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Formula is a parsed KPI expression such as "omset / biaya" or "(#2 - #3) / #2 * 100".
+// References are KPI codes or "#<id>"; only + - * /, parentheses and min/max/abs are allowed,
+// so evaluating a formula can never run arbitrary code.
+type Formula struct {
+	Source string
+	root   exprNode
+	refs   []string
+}
+
+var kpiCodePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+var formulaFuncs = map[string]func(args []float64) float64{
+	"min": func(a []float64) float64 {
+		m := a[0]
+		for _, v := range a[1:] { m = math.Min(m, v) }
+		return m
+	},
+	"max": func(a []float64) float64 {
+		m := a[0]
+		for _, v := range a[1:] { m = math.Max(m, v) }
+		return m
+	},
+	"abs": func(a []float64) float64 { return math.Abs(a[0]) },
+}
+
+func isIdentChar(ch byte, first bool) bool {
+	return ch == '_' || ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z' || !first && ch >= '0' && ch <= '9'
+}
+
+type exprNode interface {
+	eval(env map[string]float64) float64
+}
+
+type numNode float64
+type refNode string
+type negNode struct{ x exprNode }
+type binaryNode struct {
+	op   byte
+	l, r exprNode
+}
+type callNode struct {
+	fn   string
+	args []exprNode
+}
+
+func (n numNode) eval(map[string]float64) float64     { return float64(n) }
+func (n refNode) eval(env map[string]float64) float64 { return env[string(n)] }
+func (n negNode) eval(env map[string]float64) float64 { return -n.x.eval(env) }
+func (n binaryNode) eval(env map[string]float64) float64 {
+	l, r := n.l.eval(env), n.r.eval(env)
+	switch n.op {
+	case '+':
+		return l + r
+	case '-':
+		return l - r
+	case '*':
+		return l * r
+	}
+	// Division by zero yields 0, matching how ROAS treats zero cost
+	if r == 0 { return 0 }
+	return l / r
+}
+func (n callNode) eval(env map[string]float64) float64 {
+	args := make([]float64, len(n.args))
+	for i, a := range n.args { args[i] = a.eval(env) }
+	return formulaFuncs[n.fn](args)
+}
+
+// Eval evaluates the formula with reference values keyed by the reference text
+func (f *Formula) Eval(env map[string]float64) float64 {
+	v := f.root.eval(env)
+	if math.IsNaN(v) || math.IsInf(v, 0) { return 0 }
+	return v
+}
+
+// Refs lists the distinct references in source order
+func (f *Formula) Refs() []string { return f.refs }
+
+type formulaParser struct {
+	src  string
+	pos  int
+	refs []string
+	seen map[string]bool
+}
+
+// ParseFormula parses a KPI formula expression
+func ParseFormula(src string) (*Formula, error) {
+	p := &formulaParser{src: src, seen: map[string]bool{}}
+	root, err := p.parseExpr()
+	if err != nil { return nil, err }
+	p.skipSpace()
+	if p.pos < len(p.src) { return nil, fmt.Errorf("unexpected %q at position %d", p.src[p.pos], p.pos+1) }
+	return &Formula{Source: src, root: root, refs: p.refs}, nil
+}
+
+func (p *formulaParser) skipSpace() {
+	for p.pos < len(p.src) && (p.src[p.pos] == ' ' || p.src[p.pos] == '\t') { p.pos++ }
+}
+
+func (p *formulaParser) peek() byte {
+	p.skipSpace()
+	if p.pos >= len(p.src) { return 0 }
+	return p.src[p.pos]
+}
+
+// expr := term (('+' | '-') term)*
+func (p *formulaParser) parseExpr() (exprNode, error) {
+	left, err := p.parseTerm()
+	if err != nil { return nil, err }
+	for op := p.peek(); op == '+' || op == '-'; op = p.peek() {
+		p.pos++
+		right, err := p.parseTerm()
+		if err != nil { return nil, err }
+		left = binaryNode{op: op, l: left, r: right}
+	}
+	return left, nil
+}
+
+// term := unary (('*' | '/') unary)*
+func (p *formulaParser) parseTerm() (exprNode, error) {
+	left, err := p.parseUnary()
+	if err != nil { return nil, err }
+	for op := p.peek(); op == '*' || op == '/'; op = p.peek() {
+		p.pos++
+		right, err := p.parseUnary()
+		if err != nil { return nil, err }
+		left = binaryNode{op: op, l: left, r: right}
+	}
+	return left, nil
+}
+
+// unary := '-' unary | primary
+func (p *formulaParser) parseUnary() (exprNode, error) {
+	if p.peek() == '-' {
+		p.pos++
+		x, err := p.parseUnary()
+		if err != nil { return nil, err }
+		return negNode{x: x}, nil
+	}
+	return p.parsePrimary()
+}
+
+// primary := number | '#' id | ident | ident '(' expr (',' expr)* ')' | '(' expr ')'
+func (p *formulaParser) parsePrimary() (exprNode, error) {
+	ch := p.peek()
+	start := p.pos
+	switch {
+	case ch == 0:
+		return nil, fmt.Errorf("unexpected end of formula")
+	case ch == '(':
+		p.pos++
+		x, err := p.parseExpr()
+		if err != nil { return nil, err }
+		if p.peek() != ')' { return nil, fmt.Errorf("missing ')' at position %d", p.pos+1) }
+		p.pos++
+		return x, nil
+	case ch == '#':
+		p.pos++
+		for p.pos < len(p.src) && p.src[p.pos] >= '0' && p.src[p.pos] <= '9' { p.pos++ }
+		if p.pos == start+1 { return nil, fmt.Errorf("expected KPI id after '#' at position %d", start+1) }
+		return p.addRef(p.src[start:p.pos]), nil
+	case ch >= '0' && ch <= '9' || ch == '.':
+		for p.pos < len(p.src) && (p.src[p.pos] >= '0' && p.src[p.pos] <= '9' || p.src[p.pos] == '.') { p.pos++ }
+		v, err := strconv.ParseFloat(p.src[start:p.pos], 64)
+		if err != nil { return nil, fmt.Errorf("invalid number %q", p.src[start:p.pos]) }
+		return numNode(v), nil
+	case isIdentChar(ch, true):
+		for p.pos < len(p.src) && isIdentChar(p.src[p.pos], false) { p.pos++ }
+		name := p.src[start:p.pos]
+		if p.peek() != '(' { return p.addRef(name), nil }
+		fn := strings.ToLower(name)
+		if _, ok := formulaFuncs[fn]; !ok { return nil, fmt.Errorf("unknown function %q", name) }
+		p.pos++
+		var args []exprNode
+		for {
+			arg, err := p.parseExpr()
+			if err != nil { return nil, err }
+			args = append(args, arg)
+			if p.peek() != ',' { break }
+			p.pos++
+		}
+		if p.peek() != ')' { return nil, fmt.Errorf("missing ')' after arguments of %s", name) }
+		p.pos++
+		if fn == "abs" && len(args) != 1 { return nil, fmt.Errorf("abs takes exactly one argument") }
+		return callNode{fn: fn, args: args}, nil
+	}
+	return nil, fmt.Errorf("unexpected %q at position %d", ch, p.pos+1)
+}
+
+func (p *formulaParser) addRef(ref string) exprNode {
+	if !p.seen[ref] { p.seen[ref] = true; p.refs = append(p.refs, ref) }
+	return refNode(ref)
+}
+
+// resolveFormulaRef maps a reference ("#12" or a code) to a KPI id within the set
+func resolveFormulaRef(ref string, byCode map[string]uint, ids map[uint]bool) (uint, error) {
+	if strings.HasPrefix(ref, "#") {
+		id, _ := strconv.ParseUint(ref[1:], 10, 64)
+		if !ids[uint(id)] { return 0, fmt.Errorf("unknown KPI %s", ref) }
+		return uint(id), nil
+	}
+	if id, ok := byCode[ref]; ok { return id, nil }
+	return 0, fmt.Errorf("unknown KPI code %q", ref)
+}
+
+// formulaPlan is a dependency-ordered set of derived KPIs ready for evaluation
+type formulaPlan struct {
+	order    []uint
+	formulas map[uint]*Formula
+	deps     map[uint]map[string]uint // formula KPI -> reference text -> referenced KPI id
+}
+
+// planFormulas resolves references and orders derived KPIs so that every formula is
+// evaluated after the KPIs it depends on. Cycles and unknown references are errors.
+func planFormulas(kpiConfigs []KpiConfig, formulas map[uint]*Formula) (formulaPlan, error) {
+	plan := formulaPlan{formulas: formulas, deps: map[uint]map[string]uint{}}
+	byCode := map[string]uint{}
+	ids := map[uint]bool{}
+	names := map[uint]string{}
+	for _, k := range kpiConfigs {
+		ids[k.ID] = true
+		names[k.ID] = k.Name
+		if k.Code != "" { byCode[k.Code] = k.ID }
+	}
+	keys := make([]uint, 0, len(formulas))
+	for id, f := range formulas {
+		keys = append(keys, id)
+		plan.deps[id] = map[string]uint{}
+		for _, ref := range f.Refs() {
+			dep, err := resolveFormulaRef(ref, byCode, ids)
+			if err != nil { return plan, fmt.Errorf("formula of %q: %w", names[id], err) }
+			if dep == id { return plan, fmt.Errorf("formula of %q references itself", names[id]) }
+			plan.deps[id][ref] = dep
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+
+	const (
+		unvisited = iota
+		visiting
+		done
+	)
+	state := map[uint]int{}
+	var visit func(id uint, path []string) error
+	visit = func(id uint, path []string) error {
+		switch state[id] {
+		case visiting:
+			return fmt.Errorf("circular formula dependency: %s", strings.Join(append(path, names[id]), " -> "))
+		case done:
+			return nil
+		}
+		state[id] = visiting
+		deps := make([]uint, 0, len(plan.deps[id]))
+		for _, dep := range plan.deps[id] { deps = append(deps, dep) }
+		sort.Slice(deps, func(i, j int) bool { return deps[i] < deps[j] })
+		for _, dep := range deps {
+			if _, derived := formulas[dep]; !derived { continue }
+			if err := visit(dep, append(path, names[id])); err != nil { return err }
+		}
+		state[id] = done
+		plan.order = append(plan.order, id)
+		return nil
+	}
+	for _, id := range keys {
+		if err := visit(id, nil); err != nil { return plan, err }
+	}
+	return plan, nil
+}
+
+// evaluate fills values for every derived KPI, in dependency order
+func (plan formulaPlan) evaluate(values map[uint]float64) {
+	for _, id := range plan.order {
+		env := map[string]float64{}
+		for ref, dep := range plan.deps[id] { env[ref] = values[dep] }
+		values[id] = plan.formulas[id].Eval(env)
+	}
+}
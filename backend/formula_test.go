@@ -0,0 +1,105 @@
+package main
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func strPtr(s string) *string { return &s }
+
+func TestParseFormula(t *testing.T) {
+	tests := []struct {
+		src  string
+		env  map[string]float64
+		want float64
+		refs []string
+		err  string
+	}{
+		{src: "omset / biaya", env: map[string]float64{"omset": 100, "biaya": 25}, want: 4, refs: []string{"omset", "biaya"}},
+		{src: "(#2 - #3) / #2 * 100", env: map[string]float64{"#2": 200, "#3": 50}, want: 75, refs: []string{"#2", "#3"}},
+		{src: "-a + 2 * b", env: map[string]float64{"a": 1, "b": 3}, want: 5, refs: []string{"a", "b"}},
+		{src: "max(a, b, 7) + MIN(a, b) + abs(-2)", env: map[string]float64{"a": 1, "b": 3}, want: 10, refs: []string{"a", "b"}},
+		{src: "a / b", env: map[string]float64{"a": 5}, want: 0, refs: []string{"a", "b"}}, // division by zero is 0
+		{src: "a + a * a", env: map[string]float64{"a": 2}, want: 6, refs: []string{"a"}},
+		{src: "", err: "unexpected end"},
+		{src: "a +", err: "unexpected end"},
+		{src: "(a + b", err: "missing ')'"},
+		{src: "a b", err: "unexpected"},
+		{src: "# + 1", err: "expected KPI id"},
+		{src: "pow(a, 2)", err: "unknown function"},
+		{src: "abs(a, b)", err: "exactly one argument"},
+		{src: "1..2", err: "invalid number"},
+		{src: "a $ b", err: "unexpected"},
+	}
+	for _, tt := range tests {
+		f, err := ParseFormula(tt.src)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) { t.Errorf("ParseFormula(%q) error = %v, want %q", tt.src, err, tt.err) }
+			continue
+		}
+		if err != nil { t.Errorf("ParseFormula(%q) error = %v", tt.src, err); continue }
+		if got := f.Eval(tt.env); math.Abs(got-tt.want) > 1e-9 { t.Errorf("Eval(%q) = %v, want %v", tt.src, got, tt.want) }
+		if strings.Join(f.Refs(), ",") != strings.Join(tt.refs, ",") { t.Errorf("Refs(%q) = %v, want %v", tt.src, f.Refs(), tt.refs) }
+	}
+}
+
+func TestPlanFormulas(t *testing.T) {
+	kpi := func(id uint, code, formula string) KpiConfig {
+		k := KpiConfig{ID: id, Name: "KPI " + string(rune('A'+id-1)), Code: code}
+		if formula != "" { k.Formula = strPtr(formula) }
+		return k
+	}
+	tests := []struct {
+		name  string
+		kpis  []KpiConfig
+		order []uint
+		err   string
+	}{
+		{name: "entered only", kpis: []KpiConfig{kpi(1, "a", ""), kpi(2, "b", "")}},
+		{name: "chain", kpis: []KpiConfig{kpi(1, "a", ""), kpi(2, "b", "c * 2"), kpi(3, "c", "a + 1")}, order: []uint{3, 2}},
+		{name: "by id", kpis: []KpiConfig{kpi(1, "", ""), kpi(2, "", "#1 / 2")}, order: []uint{2}},
+		{name: "self reference", kpis: []KpiConfig{kpi(1, "a", "a + 1")}, err: "references itself"},
+		{name: "two-node cycle", kpis: []KpiConfig{kpi(1, "a", "b"), kpi(2, "b", "a")}, err: "circular formula dependency: KPI A -> KPI B -> KPI A"},
+		{name: "three-node cycle", kpis: []KpiConfig{kpi(1, "a", "c"), kpi(2, "b", "a"), kpi(3, "c", "b"), kpi(4, "d", "")}, err: "circular"},
+		{name: "unknown code", kpis: []KpiConfig{kpi(1, "a", "zz")}, err: `unknown KPI code "zz"`},
+		{name: "unknown id", kpis: []KpiConfig{kpi(1, "a", "#9")}, err: "unknown KPI #9"},
+	}
+	for _, tt := range tests {
+		formulas := map[uint]*Formula{}
+		for _, k := range tt.kpis {
+			if k.Formula == nil { continue }
+			f, err := ParseFormula(*k.Formula)
+			if err != nil { t.Fatalf("%s: %v", tt.name, err) }
+			formulas[k.ID] = f
+		}
+		plan, err := planFormulas(tt.kpis, formulas)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) { t.Errorf("%s: error = %v, want %q", tt.name, err, tt.err) }
+			continue
+		}
+		if err != nil { t.Errorf("%s: error = %v", tt.name, err); continue }
+		if len(plan.order) != len(tt.order) { t.Errorf("%s: order = %v, want %v", tt.name, plan.order, tt.order); continue }
+		for i := range tt.order {
+			if plan.order[i] != tt.order[i] { t.Errorf("%s: order = %v, want %v", tt.name, plan.order, tt.order); break }
+		}
+	}
+}
+
+// A legacy ROAS row derives "omset / biaya"; an omset formula referring back to ROAS closes a
+// cycle ValidateKpiFormulas cannot see, which CalculateBonus must report instead of paying 0s
+func TestCalculateBonusReportsLegacyRoasCycle(t *testing.T) {
+	kpis := []KpiConfig{
+		{ID: 1, Name: "ROAS", Platform: "Shopee", Bobot: 10, Target: 5, Type: "higher_is_better", SpecialCalc: strPtr("ROAS"), Role: KpiRoleRatio},
+		{ID: 2, Name: "Omset", Platform: "Shopee", Bobot: 10, Target: 100, Type: "higher_is_better", Role: KpiRoleRevenue, Formula: strPtr("#1 * 2")},
+		{ID: 3, Name: "Biaya", Platform: "Shopee", Bobot: 10, Target: 10, Type: "lower_is_better", Role: KpiRoleCost},
+	}
+	if err := ValidateKpiFormulas(kpis); err != nil { t.Fatalf("ValidateKpiFormulas: %v", err) }
+	res := CalculateBonus(kpis, nil, nil, map[uint]string{3: "10"}, "POINTS_BASED", nil, BonusSettings{})
+	if len(res.InputErrors) != 1 || !strings.Contains(res.InputErrors[0].Error, "circular") { t.Fatalf("InputErrors = %+v, want a circular formula error", res.InputErrors) }
+
+	kpis[1].Formula = nil
+	res = CalculateBonus(kpis, nil, nil, map[uint]string{2: "100", 3: "10"}, "POINTS_BASED", nil, BonusSettings{})
+	if len(res.InputErrors) != 0 { t.Fatalf("InputErrors = %+v, want none", res.InputErrors) }
+	if res.Details[0].Realisasi != 10 { t.Errorf("ROAS realisasi = %v, want 10", res.Details[0].Realisasi) }
+}
@@ -0,0 +1,399 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultHistoryPageSize = 50
+	maxHistoryPageSize     = 200
+)
+
+// Sort keys of GET /history and the SQL expression each orders by
+var historySortColumns = map[string]string{"created_at": "created_at", "bonus": "bonus", "points": "total_points", "period": periodKeySQL}
+
+// Client-submitted totals may differ from the server calculation by rounding only
+const (
+	historyAbsTolerance = 0.01
+	historyRelTolerance = 0.001
+)
+
+// HistoryResponse is the API shape of a HistoryEntry with its results decoded
+type HistoryResponse struct {
+	ID              uint               `json:"id"`
+	DivisionID      uint               `json:"divisionId"`
+	EmployeeID      uint               `json:"employeeId"`
+	EmployeeName    string             `json:"employeeName"`
+	Date            time.Time          `json:"date"`
+	PeriodMonth     string             `json:"periodMonth"`
+	PeriodYear      int                `json:"periodYear"`
+	PeriodID        *uint              `json:"periodId"`
+	TotalPoints     float64            `json:"totalPoints"`
+	Bonus           float64            `json:"bonus"`
+	AdjustedBonus   *float64           `json:"adjustedBonus"`
+	PayrollRunID    *uint              `json:"payrollRunId"`
+	Results         *CalculationResult `json:"results,omitempty"`
+	RealisasiInputs map[uint]string    `json:"realisasiInputs,omitempty"`
+	Grade           string             `json:"grade"`
+	PdfURL          string             `json:"pdfUrl"`
+	ConfigVersionID *uint              `json:"configVersionId"`
+	Status          string             `json:"status"`
+	StatusReason    string             `json:"statusReason"`
+	StatusChangedBy string             `json:"statusChangedBy"`
+	StatusChangedAt *time.Time         `json:"statusChangedAt"`
+	Revision        int                `json:"revision"`
+	PreviousID      *uint              `json:"previousId"`
+	IsCurrent       bool               `json:"isCurrent"`
+	AmendReason     string             `json:"amendReason"`
+}
+
+// HistoryCreateRequest carries the raw realisasi inputs; results are always computed server-side.
+// TotalPoints and Bonus are optional and only used to detect a client that disagrees with the server.
+type HistoryCreateRequest struct {
+	DivisionID      uint            `json:"divisionId"`
+	DivisionName    string          `json:"divisionName"`
+	EmployeeID      uint            `json:"employeeId"`
+	EmployeeName    string          `json:"employeeName"`
+	Date            string          `json:"date"`
+	PeriodMonth     string          `json:"periodMonth"`
+	PeriodYear      int             `json:"periodYear"`
+	RealisasiInputs map[uint]string `json:"realisasiInputs"`
+	TotalPoints     *float64        `json:"totalPoints"`
+	Bonus           *float64        `json:"bonus"`
+}
+
+var errDuplicateHistory = errors.New("duplicate history for employee and period")
+
+// historyExists reports whether the employee already has history for the period
+func historyExists(tx *gorm.DB, divisionID, employeeID uint, p Period) (bool, error) {
+	var cnt int64
+	err := tx.Model(&HistoryEntry{}).Where("division_id=? AND employee_id=? AND is_current=? AND period_id = (SELECT id FROM periods WHERE year=? AND month=?)",
+		divisionID, employeeID, true, p.Year, p.Month).Count(&cnt).Error
+	return cnt > 0, err
+}
+
+// newHistoryEntry builds a draft entry from server-side results and the raw inputs and grade they
+// were calculated from
+func newHistoryEntry(divisionID, employeeID uint, employeeName, grade string, date time.Time, p Period, inputs map[uint]string, results CalculationResult) (HistoryEntry, error) {
+	b, err := json.Marshal(results)
+	if err != nil { return HistoryEntry{}, err }
+	in, err := json.Marshal(inputs)
+	if err != nil { return HistoryEntry{}, err }
+	return HistoryEntry{
+		DivisionID:   divisionID,
+		EmployeeID:   employeeID,
+		EmployeeName: employeeName,
+		Date:         date,
+		PeriodMonth:  p.MonthName(),
+		PeriodYear:   p.Year,
+		TotalPoints:  results.GrandTotalPoin,
+		Bonus:        results.FinalBonus,
+		ResultsJSON:  string(b),
+		InputsJSON:   string(in),
+		Grade:        grade,
+		Status:       StatusDraft,
+		Revision:     1,
+		IsCurrent:    true,
+	}, nil
+}
+
+// createHistory links the entry to its period and pins it to the exact configuration the server
+// calculated with, then stores it with its KPI rows and an audit record. The duplicate check runs
+// in the caller's transaction; idx_history_current_period catches a concurrent save that still
+// slips in between.
+func createHistory(tx *gorm.DB, cfg DivisionConfig, entry *HistoryEntry) error {
+	period, err := newPeriod(entry.PeriodMonth, entry.PeriodYear)
+	if err != nil { return err }
+	if err := ensurePeriod(tx, &period); err != nil { return err }
+	if err := checkPeriodOpen(tx, entry.DivisionID, period); err != nil { return err }
+	exists, err := historyExists(tx, entry.DivisionID, entry.EmployeeID, period)
+	if err != nil { return err }
+	if exists { return errDuplicateHistory }
+	version, err := snapshotConfig(tx, cfg)
+	if err != nil { return err }
+	entry.PeriodID, entry.ConfigVersionID = &period.ID, &version.ID
+	if err := tx.Create(entry).Error; err != nil {
+		if strings.Contains(err.Error(), "UNIQUE constraint failed") { return errDuplicateHistory }
+		return err
+	}
+	if err := markPayrollStale(tx, entry.DivisionID, period.ID, "history saved or amended"); err != nil { return err }
+	if err := saveHistoryKpiResults(tx, *entry, cfg); err != nil { return err }
+	return recordAudit(tx, AuditCreate, "history", nil, entry)
+}
+
+// historyGrade returns the grade an entry was calculated with. Entries saved before the grade was
+// stored fall back to the employee's current grade, or "" once the employee is deleted.
+func historyGrade(tx *gorm.DB, entry HistoryEntry) (string, error) {
+	if entry.Grade != "" { return entry.Grade, nil }
+	var employee Employee
+	err := tx.Select("grade").First(&employee, entry.EmployeeID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) { return "", nil }
+	return employee.Grade, err
+}
+
+func toHistoryResponse(it HistoryEntry) HistoryResponse {
+	var res CalculationResult
+	if it.ResultsJSON != "" { _ = json.Unmarshal([]byte(it.ResultsJSON), &res) }
+	var inputs map[uint]string
+	if it.InputsJSON != "" { _ = json.Unmarshal([]byte(it.InputsJSON), &inputs) }
+	return HistoryResponse{
+		ID: it.ID, DivisionID: it.DivisionID, EmployeeID: it.EmployeeID, EmployeeName: it.EmployeeName,
+		Date: it.Date, PeriodMonth: it.PeriodMonth, PeriodYear: it.PeriodYear, PeriodID: it.PeriodID,
+		TotalPoints: it.TotalPoints, Bonus: it.Bonus, AdjustedBonus: it.AdjustedBonus, PayrollRunID: it.PayrollRunID, Results: &res, RealisasiInputs: inputs, Grade: it.Grade, PdfURL: fmt.Sprintf("/history/%d/pdf", it.ID),
+		ConfigVersionID: it.ConfigVersionID, Status: it.Status, StatusReason: it.StatusReason,
+		StatusChangedBy: it.StatusChangedBy, StatusChangedAt: it.StatusChangedAt,
+		Revision: it.Revision, PreviousID: it.PreviousID, IsCurrent: it.IsCurrent, AmendReason: it.AmendReason,
+	}
+}
+
+// historyCursor is the sort value and id of the last row of a page; the next page starts after it
+type historyCursor struct {
+	Value any  `json:"v"`
+	ID    uint `json:"id"`
+}
+
+func encodeHistoryCursor(sort string, e HistoryEntry) string {
+	cur := historyCursor{ID: e.ID}
+	switch sort {
+	case "bonus":
+		cur.Value = e.Bonus
+	case "points":
+		cur.Value = e.TotalPoints
+	case "period":
+		cur.Value = periodKey(e.PeriodYear, e.PeriodMonth)
+	default:
+		cur.Value = e.CreatedAt.Format(time.RFC3339Nano)
+	}
+	b, _ := json.Marshal(cur)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// decodeHistoryCursor returns the cursor's sort value as a query argument for the given sort
+func decodeHistoryCursor(v, sort string) (any, uint, error) {
+	var cur historyCursor
+	b, err := base64.RawURLEncoding.DecodeString(v)
+	if err == nil { err = json.Unmarshal(b, &cur) }
+	if err != nil { return nil, 0, errors.New("invalid cursor") }
+	if sort == "created_at" {
+		s, _ := cur.Value.(string)
+		t, err := time.Parse(time.RFC3339Nano, s)
+		if err != nil { return nil, 0, errors.New("cursor does not match sort") }
+		return t, cur.ID, nil
+	}
+	f, ok := cur.Value.(float64)
+	if !ok { return nil, 0, errors.New("cursor does not match sort") }
+	return f, cur.ID, nil
+}
+
+// checkSubmittedTotals answers 422 when client-submitted totals disagree with the server results
+func checkSubmittedTotals(c *gin.Context, totalPoints, bonus *float64, results CalculationResult) bool {
+	if withinTolerance(totalPoints, results.GrandTotalPoin) && withinTolerance(bonus, results.FinalBonus) { return true }
+	c.JSON(http.StatusUnprocessableEntity, gin.H{
+		"error":  "submitted totals do not match server calculation",
+		"client": gin.H{"totalPoints": totalPoints, "bonus": bonus},
+		"server": gin.H{"totalPoints": results.GrandTotalPoin, "bonus": results.FinalBonus},
+	})
+	return false
+}
+
+// withinTolerance reports whether a client-supplied value matches the server value;
+// a nil client value means the client did not send one and is accepted.
+func withinTolerance(client *float64, server float64) bool {
+	if client == nil { return true }
+	diff := math.Abs(*client - server)
+	return diff <= historyAbsTolerance || diff <= math.Abs(server)*historyRelTolerance
+}
+
+// historyResponses converts a list of entries, leaving out the decoded results when omitted
+func historyResponses(items []HistoryEntry, omitResults bool) []HistoryResponse {
+	responses := make([]HistoryResponse, 0, len(items))
+	for _, it := range items {
+		resp := toHistoryResponse(it)
+		if omitResults { resp.Results = nil }
+		responses = append(responses, resp)
+	}
+	return responses
+}
+
+func registerHistoryRoutes(r *gin.RouterGroup) {
+	// GET /history with filters: division_id or division_name, employee_id, status, period_month,
+	// period_year, quarter, from/to (YYYY-MM), min_bonus/max_bonus, min_points/max_points,
+	// indicator and omset_indicator (by name); revisions=all includes superseded revisions.
+	// sort=created_at|bonus|points|period with
+	// order=asc|desc. Without paging parameters every matching row is returned as a plain array;
+	// page/page_size, or the nextCursor of the previous page passed as cursor, return one page as
+	// {total, pageSize, page, nextCursor, items}. omit=results leaves out the decoded results and
+	// inputs. Results are always limited to what the caller may read.
+	r.GET("/history", func(c *gin.Context) {
+		q := scopeHistory(db.Model(&HistoryEntry{}), currentUser(c))
+		if dn := c.Query("division_name"); dn != "" {
+			var div Division
+			if err := db.Where("name = ?", dn).First(&div).Error; err == nil {
+				q = q.Where("division_id = ?", div.ID)
+			}
+		}
+		if did := c.Query("division_id"); did != "" { q = q.Where("division_id = ?", did) }
+		if eid := c.Query("employee_id"); eid != "" { q = q.Where("employee_id = ?", eid) }
+		if st := c.Query("status"); st != "" { q = q.Where("status = ?", st) }
+		if c.Query("revisions") != "all" { q = q.Where("is_current = ?", true) }
+		q, ok := filterHistoryPeriod(c, q)
+		if !ok { return }
+		for param, cond := range map[string]string{"min_bonus": "bonus >= ?", "max_bonus": "bonus <= ?", "min_points": "total_points >= ?", "max_points": "total_points <= ?"} {
+			v := c.Query(param)
+			if v == "" { continue }
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": "invalid " + param}); return }
+			q = q.Where(cond, f)
+		}
+		if v := c.Query("indicator"); v != "" { q = q.Where("LOWER(json_extract(results_json, '$.kpiIndicator.name')) = LOWER(?)", v) }
+		if v := c.Query("omset_indicator"); v != "" { q = q.Where("LOWER(json_extract(results_json, '$.omsetIndicator.name')) = LOWER(?)", v) }
+
+		omitResults := false
+		for _, f := range strings.Split(c.Query("omit"), ",") {
+			switch strings.TrimSpace(f) {
+			case "":
+			case "results":
+				omitResults = true
+			default:
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("cannot omit %q", f)}); return
+			}
+		}
+
+		sort := c.DefaultQuery("sort", "created_at")
+		column, ok := historySortColumns[sort]
+		if !ok { c.JSON(http.StatusBadRequest, gin.H{"error": "sort must be created_at, bonus, points or period"}); return }
+		order := strings.ToLower(c.DefaultQuery("order", "desc"))
+		if order != "asc" && order != "desc" { c.JSON(http.StatusBadRequest, gin.H{"error": "order must be asc or desc"}); return }
+		pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", strconv.Itoa(defaultHistoryPageSize)))
+		if pageSize < 1 { pageSize = defaultHistoryPageSize }
+		if pageSize > maxHistoryPageSize { pageSize = maxHistoryPageSize }
+
+		_, hasPage := c.GetQuery("page")
+		_, hasPageSize := c.GetQuery("page_size")
+		_, hasCursor := c.GetQuery("cursor")
+		paged := hasPage || hasPageSize || hasCursor
+		if omitResults { q = q.Omit("results_json", "inputs_json") }
+		if !paged {
+			var items []HistoryEntry
+			if err := q.Order(column + " " + order + ", id " + order).Find(&items).Error; err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()}); return }
+			c.JSON(http.StatusOK, historyResponses(items, omitResults))
+			return
+		}
+
+		var total int64
+		if err := q.Count(&total).Error; err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()}); return }
+		body := gin.H{"total": total, "pageSize": pageSize}
+		if cur, useCursor := c.GetQuery("cursor"); useCursor && cur != "" {
+			value, id, err := decodeHistoryCursor(cur, sort)
+			if err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()}); return }
+			cmp := "<"
+			if order == "asc" { cmp = ">" }
+			q = q.Where(fmt.Sprintf("(%s %s ? OR (%s = ? AND id %s ?))", column, cmp, column, cmp), value, value, id)
+		} else if !useCursor {
+			page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+			if page < 1 { page = 1 }
+			q = q.Offset((page - 1) * pageSize)
+			body["page"] = page
+		}
+
+		var items []HistoryEntry
+		if err := q.Order(column + " " + order + ", id " + order).Limit(pageSize + 1).Find(&items).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()}); return
+		}
+		body["nextCursor"] = nil
+		if len(items) > pageSize {
+			items = items[:pageSize]
+			body["nextCursor"] = encodeHistoryCursor(sort, items[pageSize-1])
+		}
+		body["items"] = historyResponses(items, omitResults)
+		c.JSON(http.StatusOK, body)
+	})
+
+	// POST /history recalculates from realisasi inputs against the division's stored configuration
+	r.POST("/history", requireRole(RoleAdmin, RoleManager), func(c *gin.Context) {
+		var req HistoryCreateRequest
+		if err := c.ShouldBindJSON(&req); err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()}); return }
+
+		var divisionID uint = req.DivisionID
+		if divisionID == 0 && strings.TrimSpace(req.DivisionName) != "" {
+			var div Division
+			if err := db.Where("name = ?", req.DivisionName).First(&div).Error; err == nil {
+				divisionID = div.ID
+			}
+		}
+		if divisionID == 0 { c.JSON(http.StatusBadRequest, gin.H{"error":"divisionId or valid divisionName is required"}); return }
+		if !currentUser(c).canManageDivision(divisionID) { c.JSON(http.StatusForbidden, gin.H{"error":"not allowed to save history for this division"}); return }
+		if len(req.RealisasiInputs) == 0 { c.JSON(http.StatusBadRequest, gin.H{"error":"realisasiInputs is required"}); return }
+		period, err := newPeriod(req.PeriodMonth, req.PeriodYear)
+		if err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()}); return }
+
+		cfg, err := loadDivisionConfig(db, divisionID)
+		if errors.Is(err, gorm.ErrRecordNotFound) { c.JSON(http.StatusBadRequest, gin.H{"error":"division not found"}); return }
+		if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()}); return }
+
+		var employee Employee
+		if err := db.Where("id = ? AND division_id = ?", req.EmployeeID, divisionID).First(&employee).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) { c.JSON(http.StatusBadRequest, gin.H{"error":"employee not found in division"}); return }
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()}); return
+		}
+
+		if err := checkPeriodOpen(db, divisionID, period); err != nil { respondPeriodError(c, err); return }
+		// Check duplicate: per division, employee, period
+		exists, err := historyExists(db, divisionID, req.EmployeeID, period)
+		if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()}); return }
+		if exists { c.JSON(http.StatusConflict, gin.H{"error": errDuplicateHistory.Error()}); return }
+
+		results := cfg.Calculate(employee.Grade, req.RealisasiInputs)
+		if len(results.InputErrors) > 0 { c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "invalid realisasi inputs", "inputErrors": results.InputErrors}); return }
+		if !checkSubmittedTotals(c, req.TotalPoints, req.Bonus, results) { return }
+
+		parsedDate := time.Now()
+		if t, err := time.Parse(time.RFC3339, req.Date); err == nil { parsedDate = t }
+
+		employeeName := req.EmployeeName
+		if strings.TrimSpace(employeeName) == "" { employeeName = employee.Name }
+		entry, err := newHistoryEntry(divisionID, req.EmployeeID, employeeName, employee.Grade, parsedDate, period, req.RealisasiInputs, results)
+		if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()}); return }
+		err = db.WithContext(c).Transaction(func(tx *gorm.DB) error { return createHistory(tx, cfg, &entry) })
+		if errors.Is(err, errDuplicateHistory) { c.JSON(http.StatusConflict, gin.H{"error": err.Error()}); return }
+		if err != nil { respondPeriodError(c, err); return }
+
+		c.JSON(http.StatusCreated, toHistoryResponse(entry))
+	})
+
+	// DELETE /history/:id
+	r.DELETE("/history/:id", requireRole(RoleAdmin, RoleManager), func(c *gin.Context) {
+		id, ok := parseID(c)
+		if !ok { return }
+		var entry HistoryEntry
+		if err := db.First(&entry, id).Error; err != nil { respondLookupError(c, "history entry", err); return }
+		if !currentUser(c).canManageDivision(entry.DivisionID) { c.JSON(http.StatusForbidden, gin.H{"error":"not allowed to delete history for this division"}); return }
+		if isLockedStatus(entry.Status) { c.JSON(http.StatusLocked, gin.H{"error":"history entry is " + entry.Status + " and can no longer be deleted"}); return }
+		if !entry.IsCurrent { c.JSON(http.StatusConflict, gin.H{"error":"superseded revisions are kept in the revision chain and cannot be deleted"}); return }
+		err := db.WithContext(c).Transaction(func(tx *gorm.DB) error {
+			if err := checkHistoryOpen(tx, entry); err != nil { return err }
+			if err := tx.Where("history_id = ?", entry.ID).Delete(&HistoryKpiResult{}).Error; err != nil { return err }
+			if err := tx.Delete(&entry).Error; err != nil { return err }
+			if err := recordAudit(tx, AuditDelete, "history", &entry, nil); err != nil { return err }
+			if entry.PeriodID != nil {
+				if err := markPayrollStale(tx, entry.DivisionID, *entry.PeriodID, "history deleted"); err != nil { return err }
+			}
+			// Deleting an amendment makes the revision it amended current again
+			if entry.PreviousID == nil { return nil }
+			return setCurrentRevision(tx, *entry.PreviousID, true)
+		})
+		if err != nil { respondPeriodError(c, err); return }
+		c.Status(http.StatusNoContent)
+	})
+}
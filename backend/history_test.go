@@ -0,0 +1,84 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// testDivision is a division the handler tests own: the seeded ones come out of a map, so
+// their ids change from run to run
+type testDivision struct {
+	Division
+	Employee     Employee
+	Omset, Biaya KpiConfig
+	Inputs       map[uint]string // realisasi that earns a bonus
+}
+
+func (s *testServer) seedDivision() testDivision {
+	s.t.Helper()
+	d := testDivision{Division: Division{Name: "Divisi Uji", BonusCalculationMethod: "OMSET_BASED", SchemeMode: "step"}}
+	if err := db.Create(&d.Division).Error; err != nil { s.t.Fatal(err) }
+	d.Employee = Employee{DivisionID: d.ID, Name: "Sari Penguji", Grade: "Junior"}
+	d.Omset = KpiConfig{DivisionID: d.ID, Platform: "Shopee", Name: "Omset", Bobot: 60, Target: 100000000, Type: "higher_is_better", IsCurrency: true, PointCapping: "uncapped", Role: KpiRoleRevenue}
+	d.Biaya = KpiConfig{DivisionID: d.ID, Platform: "Shopee", Name: "Biaya Iklan", Bobot: 40, Target: 10000000, Type: "lower_is_better", IsCurrency: true, PointCapping: "uncapped", Role: KpiRoleCost}
+	for _, v := range []any{&d.Employee, &d.Omset, &d.Biaya, &BonusScheme{DivisionID: d.ID, Name: "Dasar", Threshold: 50000000, Multiplier: 10}, &KpiIndicator{DivisionID: d.ID, Name: "Good", Threshold: 0}} {
+		if err := db.Create(v).Error; err != nil { s.t.Fatal(err) }
+	}
+	if _, err := snapshotDivisionConfig(db, d.ID); err != nil { s.t.Fatal(err) }
+	d.Inputs = map[uint]string{d.Omset.ID: "120000000", d.Biaya.ID: "8000000"}
+	return d
+}
+
+// saveHistory stores an entry for the division's employee through POST /history
+func (s *testServer) saveHistory(d testDivision, month string, year int) HistoryResponse {
+	s.t.Helper()
+	var entry HistoryResponse
+	s.decode(s.do(http.MethodPost, "/history", gin.H{"divisionId": d.ID, "employeeId": d.Employee.ID, "periodMonth": month, "periodYear": year, "realisasiInputs": d.Inputs}), http.StatusCreated, &entry)
+	return entry
+}
+
+func TestCreateHistoryRecalculates(t *testing.T) {
+	s := newTestServer(t)
+	d := s.seedDivision()
+	cfg, err := loadDivisionConfig(db, d.ID)
+	if err != nil { t.Fatal(err) }
+	want := cfg.Calculate(d.Employee.Grade, d.Inputs)
+	if want.FinalBonus == 0 { t.Fatal("fixture inputs earn no bonus") }
+
+	tests := []struct {
+		name     string
+		employee uint
+		month    string
+		inputs   map[uint]string
+		points   *float64
+		bonus    *float64
+		status   int
+	}{
+		{name: "no totals submitted", month: "Januari", inputs: d.Inputs, status: http.StatusCreated},
+		{name: "duplicate period", month: "Januari", inputs: d.Inputs, status: http.StatusConflict},
+		{name: "totals within tolerance", month: "Februari", inputs: d.Inputs, points: floatPtr(want.GrandTotalPoin), bonus: floatPtr(want.FinalBonus + historyAbsTolerance/2), status: http.StatusCreated},
+		{name: "bonus off", month: "Maret", inputs: d.Inputs, bonus: floatPtr(want.FinalBonus * 2), status: http.StatusUnprocessableEntity},
+		{name: "points off", month: "April", inputs: d.Inputs, points: floatPtr(want.GrandTotalPoin + 1), status: http.StatusUnprocessableEntity},
+		{name: "invalid input", month: "Mei", inputs: map[uint]string{d.Omset.ID: "abc"}, status: http.StatusUnprocessableEntity},
+		{name: "employee of another division", employee: d.Employee.ID + 1000, month: "Juni", inputs: d.Inputs, status: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		employee := d.Employee.ID
+		if tt.employee != 0 { employee = tt.employee }
+		w := s.do(http.MethodPost, "/history", gin.H{"divisionId": d.ID, "employeeId": employee, "periodMonth": tt.month, "periodYear": 2024, "realisasiInputs": tt.inputs, "totalPoints": tt.points, "bonus": tt.bonus})
+		if w.Code != tt.status { t.Errorf("%s: status = %d, want %d: %s", tt.name, w.Code, tt.status, w.Body.String()); continue }
+		if tt.status != http.StatusCreated { continue }
+		var got HistoryResponse
+		s.decode(w, http.StatusCreated, &got)
+		// The stored totals are the server's, never the submitted ones
+		if math.Abs(got.Bonus-want.FinalBonus) > 1e-9 || math.Abs(got.TotalPoints-want.GrandTotalPoin) > 1e-9 {
+			t.Errorf("%s: stored bonus %v and points %v, want %v and %v", tt.name, got.Bonus, got.TotalPoints, want.FinalBonus, want.GrandTotalPoin)
+		}
+	}
+	var count int64
+	db.Model(&HistoryEntry{}).Where("division_id = ?", d.ID).Count(&count)
+	if count != 2 { t.Errorf("%d history entries stored, want 2", count) }
+}
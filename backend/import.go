@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xuri/excelize/v2"
+)
+
+const maxImportSize = 10 << 20
+
+// Header names recognised as the employee column, compared case-insensitively
+var employeeColumnNames = map[string]bool{"employee": true, "employee_id": true, "employeeid": true, "employee id": true, "karyawan": true, "id karyawan": true, "nama": true, "nama karyawan": true, "name": true}
+
+// ImportColumn describes how one header cell was interpreted
+type ImportColumn struct {
+	Column int    `json:"column"`
+	Header string `json:"header"`
+	KpiID  *uint  `json:"kpiId"`
+	Note   string `json:"note,omitempty"` // employee column, or why the column is not imported
+}
+
+// readImportRows returns the cell grid of a CSV (comma or semicolon separated) or XLSX upload
+func readImportRows(filename string, data []byte, sheet string) ([][]string, error) {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".xlsx", ".xlsm":
+		f, err := excelize.OpenReader(bytes.NewReader(data))
+		if err != nil { return nil, fmt.Errorf("invalid xlsx file: %w", err) }
+		defer f.Close()
+		if sheet == "" { sheet = f.GetSheetName(f.GetActiveSheetIndex()) }
+		rows, err := f.GetRows(sheet)
+		if err != nil { return nil, fmt.Errorf("sheet %q: %w", sheet, err) }
+		return rows, nil
+	case ".csv", ".txt":
+		data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+		firstLine, _, _ := bytes.Cut(data, []byte("\n"))
+		r := csv.NewReader(bytes.NewReader(data))
+		// Spreadsheets in an Indonesian locale export with ';' because ',' is the decimal separator
+		if bytes.Count(firstLine, []byte(";")) > bytes.Count(firstLine, []byte(",")) { r.Comma = ';' }
+		r.FieldsPerRecord = -1
+		// The reader skips empty lines; pad them back so grid index + 1 stays the file's line number
+		var rows [][]string
+		for {
+			record, err := r.Read()
+			if err == io.EOF { break }
+			if err != nil { return nil, fmt.Errorf("invalid csv file: %w", err) }
+			line, _ := r.FieldPos(0)
+			for len(rows) < line-1 { rows = append(rows, nil) }
+			rows = append(rows, record)
+		}
+		return rows, nil
+	}
+	return nil, fmt.Errorf("unsupported file type %q; upload .csv or .xlsx", filepath.Ext(filename))
+}
+
+// matchImportKpi resolves a header to a KPI by id ("12" or "#12"), code or name
+func matchImportKpi(header string, kpis []KpiConfig) (*KpiConfig, error) {
+	h := strings.TrimSpace(header)
+	if id, err := strconv.ParseUint(strings.TrimPrefix(h, "#"), 10, 64); err == nil {
+		for i := range kpis {
+			if kpis[i].ID == uint(id) { return &kpis[i], nil }
+		}
+		return nil, fmt.Errorf("no KPI with id %d", id)
+	}
+	var found *KpiConfig
+	for i := range kpis {
+		if (kpis[i].Code != "" && kpis[i].Code == h) || strings.EqualFold(kpis[i].Name, h) {
+			if found != nil && found.ID != kpis[i].ID { return nil, fmt.Errorf("matches more than one KPI") }
+			found = &kpis[i]
+		}
+	}
+	if found == nil { return nil, fmt.Errorf("no KPI with this code or name") }
+	return found, nil
+}
+
+// matchImportEmployee resolves a cell to an employee of the division by id or name
+func matchImportEmployee(cell string, employees []Employee) (uint, error) {
+	v := strings.TrimSpace(cell)
+	if id, err := strconv.ParseUint(v, 10, 64); err == nil {
+		for _, e := range employees {
+			if e.ID == uint(id) { return e.ID, nil }
+		}
+		return 0, fmt.Errorf("no employee with id %d in division", id)
+	}
+	var matches []uint
+	for _, e := range employees {
+		if strings.EqualFold(strings.TrimSpace(e.Name), v) { matches = append(matches, e.ID) }
+	}
+	switch len(matches) {
+	case 0:
+		return 0, fmt.Errorf("no employee named %q in division", v)
+	case 1:
+		return matches[0], nil
+	}
+	return 0, fmt.Errorf("%d employees are named %q; use the employee id", len(matches), v)
+}
+
+// buildImportBatch maps the header to KPI columns and every data row to a BatchRow. Header
+// problems are returned as an error; row problems are carried on the row.
+func buildImportBatch(grid [][]string, cfg DivisionConfig, employees []Employee) ([]ImportColumn, []BatchRow, error) {
+	if len(grid) == 0 { return nil, nil, fmt.Errorf("file is empty") }
+	header := grid[0]
+	employeeCol := -1
+	columns := make([]ImportColumn, 0, len(header))
+	kpiCols := map[int]uint{}
+	seenKpi := map[uint]int{}
+	for i, h := range header {
+		col := ImportColumn{Column: i + 1, Header: strings.TrimSpace(h)}
+		switch {
+		case col.Header == "":
+			col.Note = "empty header"
+		case employeeCol < 0 && employeeColumnNames[strings.ToLower(col.Header)]:
+			employeeCol = i
+			col.Note = "employee column"
+		default:
+			kpi, err := matchImportKpi(col.Header, cfg.KpiConfigs)
+			switch {
+			case err != nil:
+				col.Note = err.Error()
+			case isDerivedKpi(*kpi):
+				col.KpiID, col.Note = &kpi.ID, "derived KPI; calculated, not imported"
+			default:
+				if prev, dup := seenKpi[kpi.ID]; dup { return nil, nil, fmt.Errorf("columns %d and %d both map to KPI %q", prev+1, i+1, kpi.Name) }
+				seenKpi[kpi.ID] = i
+				kpiCols[i] = kpi.ID
+				col.KpiID = &kpi.ID
+			}
+		}
+		columns = append(columns, col)
+	}
+	if employeeCol < 0 { return columns, nil, fmt.Errorf("no employee column; name one of the columns \"employee\", \"employee_id\" or \"nama\"") }
+	if len(kpiCols) == 0 { return columns, nil, fmt.Errorf("no column matches a KPI of the division") }
+
+	var rows []BatchRow
+	for n, record := range grid[1:] {
+		blank := true
+		for _, cell := range record { blank = blank && strings.TrimSpace(cell) == "" }
+		if blank { continue }
+		row := BatchRow{Line: n + 2, RealisasiInputs: map[uint]string{}}
+		cell := ""
+		if employeeCol < len(record) { cell = record[employeeCol] }
+		if strings.TrimSpace(cell) == "" {
+			row.Error = "employee is empty"
+		} else if id, err := matchImportEmployee(cell, employees); err != nil {
+			row.Error = err.Error()
+		} else {
+			row.EmployeeID = id
+		}
+		for col, kpiID := range kpiCols {
+			if col < len(record) && strings.TrimSpace(record[col]) != "" { row.RealisasiInputs[kpiID] = record[col] }
+		}
+		rows = append(rows, row)
+	}
+	return columns, rows, nil
+}
+
+func registerImportRoutes(r *gin.RouterGroup) {
+	// POST /divisions/:id/import (multipart: file, periodMonth, periodYear, date, sheet, dryRun)
+	// previews the realisasi of a CSV/XLSX sheet with row-level errors; with dryRun=false every
+	// row is calculated and saved as history in one transaction, or nothing is when a row fails.
+	r.POST("/divisions/:id/import", requireRole(RoleAdmin, RoleManager), func(c *gin.Context) {
+		id, ok := parseID(c)
+		if !ok { return }
+		if !currentUser(c).canManageDivision(id) { c.JSON(http.StatusForbidden, gin.H{"error": "not allowed to import for this division"}); return }
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxImportSize)
+		fh, err := c.FormFile("file")
+		if err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": "file is required"}); return }
+		f, err := fh.Open()
+		if err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()}); return }
+		data, err := io.ReadAll(f)
+		f.Close()
+		if err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()}); return }
+
+		grid, err := readImportRows(fh.Filename, data, c.PostForm("sheet"))
+		if err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()}); return }
+		cfg, err := loadDivisionConfig(db, id)
+		if err != nil { respondLookupError(c, "division", err); return }
+		var employees []Employee
+		if err := db.Where("division_id = ?", id).Find(&employees).Error; err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()}); return }
+		columns, rows, err := buildImportBatch(grid, cfg, employees)
+		if err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "columns": columns}); return }
+
+		year, _ := strconv.Atoi(c.PostForm("periodYear"))
+		dryRun := c.DefaultPostForm("dryRun", "true") != "false"
+		req := BatchCalculateRequest{PeriodMonth: c.PostForm("periodMonth"), PeriodYear: year, Date: c.PostForm("date"), Persist: !dryRun, Rows: rows}
+		runBatch(c, id, req, gin.H{"dryRun": dryRun, "filename": fh.Filename, "columns": columns})
+	})
+}
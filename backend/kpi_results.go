@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// KpiResultRow is a stored KPI result with the history entry it belongs to
+type KpiResultRow struct {
+	HistoryKpiResult
+	DivisionID   uint   `json:"divisionId"`
+	EmployeeID   uint   `json:"employeeId"`
+	EmployeeName string `json:"employeeName"`
+	PeriodMonth  string `json:"periodMonth"`
+	PeriodYear   int    `json:"periodYear"`
+}
+
+// Sort keys of GET /kpi-results
+var kpiResultSortColumns = map[string]string{"id": "history_kpi_results.id", "score": "history_kpi_results.score", "poin": "history_kpi_results.poin", "realisasi": "history_kpi_results.realisasi"}
+
+// historyKpiResults builds the KPI rows of an entry from its ResultsJSON, with name, platform,
+// target and bobot taken from the configuration the entry was calculated with
+func historyKpiResults(entry HistoryEntry, cfg DivisionConfig) ([]HistoryKpiResult, error) {
+	if entry.ResultsJSON == "" { return nil, nil }
+	var res CalculationResult
+	if err := json.Unmarshal([]byte(entry.ResultsJSON), &res); err != nil { return nil, err }
+	kpis := map[uint]KpiConfig{}
+	for _, k := range cfg.KpiConfigs { kpis[k.ID] = k }
+	rows := make([]HistoryKpiResult, 0, len(res.Details))
+	for _, d := range res.Details {
+		k := kpis[d.ID]
+		rows = append(rows, HistoryKpiResult{HistoryID: entry.ID, KpiID: d.ID, Name: k.Name, Platform: k.Platform, Target: k.Target, Bobot: k.Bobot, Realisasi: d.Realisasi, Score: d.Score, Poin: d.Poin})
+	}
+	return rows, nil
+}
+
+// saveHistoryKpiResults stores the KPI rows of a saved entry
+func saveHistoryKpiResults(tx *gorm.DB, entry HistoryEntry, cfg DivisionConfig) error {
+	rows, err := historyKpiResults(entry, cfg)
+	if err != nil || len(rows) == 0 { return err }
+	return tx.Create(&rows).Error
+}
+
+// backfillHistoryKpiResults stores KPI rows for history saved before they existed, using the
+// configuration each entry is pinned to
+func backfillHistoryKpiResults() {
+	var entries []HistoryEntry
+	if err := db.Where("id NOT IN (SELECT history_id FROM history_kpi_results)").Find(&entries).Error; err != nil {
+		log.Printf("Failed to load history for KPI result backfill: %v", err); return
+	}
+	configs := map[uint]DivisionConfig{}
+	filled := 0
+	for _, e := range entries {
+		cfg, cached := DivisionConfig{}, false
+		if e.ConfigVersionID != nil { cfg, cached = configs[*e.ConfigVersionID] }
+		if !cached {
+			var err error
+			if cfg, err = historyConfig(e); err != nil { log.Printf("History entry %d: no configuration for KPI results: %v", e.ID, err); continue }
+			if e.ConfigVersionID != nil { configs[*e.ConfigVersionID] = cfg }
+		}
+		rows, err := historyKpiResults(e, cfg)
+		if err != nil { log.Printf("History entry %d: invalid results: %v", e.ID, err); continue }
+		if len(rows) == 0 { continue }
+		if err := db.Create(&rows).Error; err != nil { log.Printf("Failed to store KPI results of history entry %d: %v", e.ID, err); return }
+		filled++
+	}
+	if filled > 0 { log.Printf("Stored KPI results for %d history entries", filled) }
+}
+
+// filterKpiResults applies kpi_id, kpi_name, platform and the min_/max_ score and poin
+// parameters to a query over history_kpi_results. On an invalid parameter it responds 400 and
+// returns false.
+func filterKpiResults(c *gin.Context, q *gorm.DB) (*gorm.DB, bool) {
+	if v := c.Query("kpi_id"); v != "" { q = q.Where("history_kpi_results.kpi_id = ?", v) }
+	if v := c.Query("kpi_name"); v != "" { q = q.Where("LOWER(history_kpi_results.name) = LOWER(?)", v) }
+	if v := c.Query("platform"); v != "" { q = q.Where("LOWER(history_kpi_results.platform) = LOWER(?)", v) }
+	for param, cond := range map[string]string{
+		"min_score": "history_kpi_results.score >= ?", "max_score": "history_kpi_results.score <= ?",
+		"min_poin": "history_kpi_results.poin >= ?", "max_poin": "history_kpi_results.poin <= ?",
+	} {
+		v := c.Query(param)
+		if v == "" { continue }
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": "invalid " + param}); return q, false }
+		q = q.Where(cond, f)
+	}
+	return q, true
+}
+
+// kpiResultsQuery selects the stored KPI results of the current history the caller may read,
+// with every history and KPI filter of the request applied
+func kpiResultsQuery(c *gin.Context) (*gorm.DB, bool) {
+	h, ok := analyticsHistory(c)
+	if !ok { return nil, false }
+	q := db.Model(&HistoryKpiResult{}).Joins("JOIN history_entries ON history_entries.id = history_kpi_results.history_id").
+		Where("history_kpi_results.history_id IN (?)", h.Select("id"))
+	q, ok = filterKpiResults(c, q)
+	return q.Session(&gorm.Session{}), ok
+}
+
+func registerKpiResultRoutes(r *gin.RouterGroup) {
+	// GET /kpi-results lists stored KPI results of current history with the history filters of
+	// /analytics (division_id, employee_id, status, period_month, period_year, quarter, from/to)
+	// and kpi_id, kpi_name, platform, min_score/max_score, min_poin/max_poin; for example
+	// ?kpi_name=ROAS Shopee&max_score=80. sort=id|score|poin|realisasi, order=asc|desc,
+	// page and page_size as in /history.
+	r.GET("/kpi-results", func(c *gin.Context) {
+		q, ok := kpiResultsQuery(c)
+		if !ok { return }
+		column, ok := kpiResultSortColumns[c.DefaultQuery("sort", "id")]
+		if !ok { c.JSON(http.StatusBadRequest, gin.H{"error": "sort must be id, score, poin or realisasi"}); return }
+		order := c.DefaultQuery("order", "asc")
+		if order != "asc" && order != "desc" { c.JSON(http.StatusBadRequest, gin.H{"error": "order must be asc or desc"}); return }
+		page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+		if page < 1 { page = 1 }
+		pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", strconv.Itoa(defaultHistoryPageSize)))
+		if pageSize < 1 { pageSize = defaultHistoryPageSize }
+		if pageSize > maxHistoryPageSize { pageSize = maxHistoryPageSize }
+
+		var total int64
+		if err := q.Count(&total).Error; err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()}); return }
+		items := []KpiResultRow{}
+		err := q.Select("history_kpi_results.*, history_entries.division_id, history_entries.employee_id, history_entries.employee_name, history_entries.period_month, history_entries.period_year").
+			Order(column + " " + order + ", history_kpi_results.id").Offset((page - 1) * pageSize).Limit(pageSize).Scan(&items).Error
+		if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()}); return }
+		c.JSON(http.StatusOK, gin.H{"items": items, "total": total, "page": page, "pageSize": pageSize})
+	})
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// KPI roles decide how a KPI feeds the omset totals and the legacy ROAS pairing
+const (
+	KpiRoleRevenue = "revenue"
+	KpiRoleCost    = "cost"
+	KpiRoleRatio   = "ratio"
+	KpiRoleGeneric = "generic"
+)
+
+var validKpiRoles = map[string]bool{KpiRoleRevenue: true, KpiRoleCost: true, KpiRoleRatio: true, KpiRoleGeneric: true}
+
+var (
+	defaultCostKeywords = []string{"biaya", "cost", "spend", "ads", "iklan"}
+	revenueKeywords     = []string{"omset", "omzet", "revenue", "penjualan", "sales", "gmv"}
+)
+
+func containsAnyKeyword(name string, keywords []string) bool {
+	n := strings.ToLower(name)
+	for _, kw := range keywords {
+		if strings.Contains(n, kw) { return true }
+	}
+	return false
+}
+
+// costKeywordMatcher reports whether a KPI name looks like a cost, using the division's
+// keywords or the defaults when none are configured
+func costKeywordMatcher(customCostKeywords []string) func(string) bool {
+	costKeywords := defaultCostKeywords
+	if len(customCostKeywords) > 0 {
+		costKeywords = make([]string, 0, len(customCostKeywords))
+		for _, k := range customCostKeywords {
+			k = strings.ToLower(strings.TrimSpace(k))
+			if k != "" { costKeywords = append(costKeywords, k) }
+		}
+	}
+	return func(name string) bool { return containsAnyKeyword(name, costKeywords) }
+}
+
+// inferKpiRole reproduces the keyword heuristic used before roles were stored: cost keywords
+// win, any other currency KPI counts as revenue, derived KPIs are ratios.
+func inferKpiRole(k KpiConfig, isCostKpi func(string) bool) string {
+	switch {
+	case isCostKpi(k.Name):
+		return KpiRoleCost
+	case k.IsCurrency:
+		return KpiRoleRevenue
+	case k.SpecialCalc != nil && *k.SpecialCalc == "ROAS", k.Formula != nil:
+		return KpiRoleRatio
+	}
+	return KpiRoleGeneric
+}
+
+// resolveKpiRole returns the stored role, inferring one for rows saved without it
+func resolveKpiRole(k KpiConfig, isCostKpi func(string) bool) string {
+	if validKpiRoles[k.Role] { return k.Role }
+	return inferKpiRole(k, isCostKpi)
+}
+
+// backfillKpiRoles stores the inferred role on every KPI that has none yet, so later keyword
+// changes no longer reclassify existing KPIs.
+func backfillKpiRoles() {
+	var divisions []Division
+	if err := db.Find(&divisions).Error; err != nil { log.Printf("Failed to load divisions for KPI roles: %v", err); return }
+	filled := 0
+	for _, d := range divisions {
+		var kpis []KpiConfig
+		if err := db.Where("division_id = ? AND (role IS NULL OR role = '')", d.ID).Find(&kpis).Error; err != nil {
+			log.Printf("Failed to load KPIs of division %s: %v", d.Name, err); continue
+		}
+		isCostKpi := costKeywordMatcher(DivisionConfig{Division: d}.CostKeywords())
+		for _, k := range kpis {
+			if err := db.Model(&KpiConfig{}).Where("id = ?", k.ID).Update("role", inferKpiRole(k, isCostKpi)).Error; err != nil {
+				log.Printf("Failed to backfill role of KPI %s: %v", k.Name, err); continue
+			}
+			filled++
+		}
+	}
+	if filled > 0 { log.Printf("Back-filled roles of %d KPIs from cost keywords", filled) }
+}
+
+// KpiRoleWarning flags a KPI whose classification deserves a second look
+type KpiRoleWarning struct {
+	KpiID        uint     `json:"kpiId"`
+	DivisionID   uint     `json:"divisionId"`
+	Name         string   `json:"name"`
+	Platform     string   `json:"platform"`
+	Role         string   `json:"role"`
+	InferredRole string   `json:"inferredRole"`
+	Reasons      []string `json:"reasons"`
+}
+
+// kpiRoleWarnings lists ambiguous or suspicious role assignments in a division configuration
+func kpiRoleWarnings(cfg DivisionConfig) []KpiRoleWarning {
+	isCostKpi := costKeywordMatcher(cfg.CostKeywords())
+	byID := map[uint]*KpiRoleWarning{}
+	var order []uint
+	warn := func(k KpiConfig, reason string) {
+		w, ok := byID[k.ID]
+		if !ok {
+			w = &KpiRoleWarning{KpiID: k.ID, DivisionID: k.DivisionID, Name: k.Name, Platform: k.Platform, Role: resolveKpiRole(k, isCostKpi), InferredRole: inferKpiRole(k, isCostKpi)}
+			byID[k.ID] = w
+			order = append(order, k.ID)
+		}
+		w.Reasons = append(w.Reasons, reason)
+	}
+
+	type platformRoles struct{ roas, revenue, cost []KpiConfig }
+	platforms := map[string]*platformRoles{}
+	var platformOrder []string
+	for _, k := range cfg.KpiConfigs {
+		role := resolveKpiRole(k, isCostKpi)
+		inferred := inferKpiRole(k, isCostKpi)
+		if k.Role == "" { warn(k, "role is not set; "+inferred+" is inferred from keywords") }
+		if isCostKpi(k.Name) && containsAnyKeyword(k.Name, revenueKeywords) { warn(k, "name matches both cost and revenue keywords") }
+		if (role == KpiRoleRevenue || role == KpiRoleCost) && !k.IsCurrency { warn(k, role+" KPI is not a currency") }
+		if role == KpiRoleCost && k.Type == "higher_is_better" { warn(k, "cost KPI is higher_is_better") }
+		if role == KpiRoleRevenue && k.Type == "lower_is_better" { warn(k, "revenue KPI is lower_is_better") }
+
+		p, ok := platforms[k.Platform]
+		if !ok { p = &platformRoles{}; platforms[k.Platform] = p; platformOrder = append(platformOrder, k.Platform) }
+		switch {
+		case k.SpecialCalc != nil && *k.SpecialCalc == "ROAS" && k.Formula == nil:
+			p.roas = append(p.roas, k)
+		case role == KpiRoleRevenue:
+			p.revenue = append(p.revenue, k)
+		case role == KpiRoleCost:
+			p.cost = append(p.cost, k)
+		}
+	}
+
+	// A legacy ROAS KPI divides the platform's revenue by its cost, so both must be unambiguous
+	for _, name := range platformOrder {
+		p := platforms[name]
+		for _, roas := range p.roas {
+			switch {
+			case len(p.revenue) == 0:
+				warn(roas, "no revenue KPI on platform "+name+"; ROAS will be 0")
+			case len(p.revenue) > 1:
+				warn(roas, fmt.Sprintf("%d revenue KPIs on platform %s; set a formula to choose one", len(p.revenue), name))
+			}
+			switch {
+			case len(p.cost) == 0:
+				warn(roas, "no cost KPI on platform "+name+"; ROAS will be 0")
+			case len(p.cost) > 1:
+				warn(roas, fmt.Sprintf("%d cost KPIs on platform %s; set a formula to choose one", len(p.cost), name))
+			}
+		}
+	}
+
+	warnings := make([]KpiRoleWarning, 0, len(order))
+	for _, id := range order { warnings = append(warnings, *byID[id]) }
+	return warnings
+}
+
+func registerKpiRoleRoutes(r *gin.RouterGroup) {
+	// GET /kpis/role-warnings?division_id= lists ambiguous KPI classifications, all readable divisions
+	// when omitted
+	r.GET("/kpis/role-warnings", func(c *gin.Context) {
+		q := scopeDivisions(db.Model(&Division{}), currentUser(c), "id")
+		if v := c.Query("division_id"); v != "" { q = q.Where("id = ?", v) }
+		var divisions []Division
+		if err := q.Order("id").Find(&divisions).Error; err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()}); return }
+		warnings := []KpiRoleWarning{}
+		for _, d := range divisions {
+			cfg, err := loadDivisionConfig(db, d.ID)
+			if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()}); return }
+			warnings = append(warnings, kpiRoleWarnings(cfg)...)
+		}
+		c.JSON(http.StatusOK, warnings)
+	})
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// useTestDB points db at a fresh seeded database for the length of the test
+func useTestDB(t *testing.T) {
+	t.Helper()
+	t.Setenv("ADMIN_PASSWORD", "secret123")
+	previous := db
+	if err := openDatabase(filepath.Join(t.TempDir(), "test.db")); err != nil { t.Fatalf("openDatabase: %v", err) }
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil { sqlDB.Close() }
+		db = previous
+	})
+}
+
+// testServer is the full router over a fresh database, logged in as the seeded admin
+type testServer struct {
+	t      *testing.T
+	router *gin.Engine
+	token  string
+}
+
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	useTestDB(t)
+	s := &testServer{t: t, router: newRouter()}
+	var login struct{ Token string `json:"token"` }
+	s.decode(s.do(http.MethodPost, "/auth/login", gin.H{"username": "admin", "password": "secret123"}), http.StatusOK, &login)
+	s.token = login.Token
+	return s
+}
+
+// do sends body as JSON, with the admin token once logged in
+func (s *testServer) do(method, path string, body any) *httptest.ResponseRecorder {
+	s.t.Helper()
+	var buf bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&buf).Encode(body); err != nil { s.t.Fatal(err) }
+	}
+	req := httptest.NewRequest(method, path, &buf)
+	req.Header.Set("Content-Type", "application/json")
+	if s.token != "" { req.Header.Set("Authorization", "Bearer "+s.token) }
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, req)
+	return w
+}
+
+// decode fails the test unless w has the wanted status, then decodes its body into v
+func (s *testServer) decode(w *httptest.ResponseRecorder, status int, v any) {
+	s.t.Helper()
+	if w.Code != status { s.t.Fatalf("status = %d, want %d: %s", w.Code, status, w.Body.String()) }
+	if v != nil {
+		if err := json.Unmarshal(w.Body.Bytes(), v); err != nil { s.t.Fatalf("decode %s: %v", w.Body.String(), err) }
+	}
+}
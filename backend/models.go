@@ -9,6 +9,10 @@ type Division struct {
 	Name                    string    `json:"name"`
 	BonusCalculationMethod  string    `json:"bonusCalculationMethod"` // OMSET_BASED | POINTS_BASED | NON_SALES
 	CostKeywords            string    `json:"costKeywords"`           // comma-separated optional
+	SchemeMode              string    `json:"schemeMode"`             // step | linear | progressive
+	BonusCap                *float64  `json:"bonusCap"`               // optional upper limit of FinalBonus
+	BonusBaseRate           *float64  `json:"bonusBaseRate"`          // rupiah per point; null uses the default of 1000
+	BonusFormula            *string   `json:"bonusFormula"`           // optional final bonus override, e.g. "points * base * multiplier"
 	CreatedAt               time.Time `json:"createdAt"`
 	UpdatedAt               time.Time `json:"updatedAt"`
 }
@@ -17,10 +21,38 @@ type Employee struct {
 	ID         uint      `json:"id" gorm:"primarykey"`
 	DivisionID uint      `json:"divisionId"`
 	Name       string    `json:"name"`
+	Grade      string    `json:"grade"` // optional; selects a GradeRate of the division
 	CreatedAt  time.Time `json:"createdAt"`
 	UpdatedAt  time.Time `json:"updatedAt"`
 }
 
+// GradeRate overrides the division's bonus base rate for employees of one grade
+type GradeRate struct {
+	ID         uint      `json:"id" gorm:"primarykey"`
+	DivisionID uint      `json:"divisionId" gorm:"index"`
+	Grade      string    `json:"grade"`
+	BaseRate   float64   `json:"baseRate"`
+	CreatedAt  time.Time `json:"createdAt"`
+	UpdatedAt  time.Time `json:"updatedAt"`
+}
+
+// PdfTemplate customises the history PDF of one division
+type PdfTemplate struct {
+	ID                  uint      `json:"id" gorm:"primarykey"`
+	DivisionID          uint      `json:"divisionId" gorm:"uniqueIndex"`
+	CompanyName         string    `json:"companyName"` // defaults to SASTRO GRUP
+	Title               string    `json:"title"`       // defaults to "Laporan Performa"
+	AccentColor         string    `json:"accentColor"` // #RRGGBB
+	PaperSize           string    `json:"paperSize"`   // A4 | Letter
+	HeaderNote          string    `json:"headerNote"`
+	FooterNote          string    `json:"footerNote"`
+	HideKpiDetails      bool      `json:"hideKpiDetails"`
+	ShowSchemeBreakdown bool      `json:"showSchemeBreakdown"`
+	SignatureLabels     string    `json:"signatureLabels"` // comma-separated, e.g. "Manager,HRD"
+	CreatedAt           time.Time `json:"createdAt"`
+	UpdatedAt           time.Time `json:"updatedAt"`
+}
+
 type BonusScheme struct {
 	ID         uint      `json:"id" gorm:"primarykey"`
 	DivisionID uint      `json:"divisionId"`
@@ -53,25 +85,119 @@ type KpiConfig struct {
 	IsCurrency   bool      `json:"isCurrency"`
 	IsPercentage bool      `json:"isPercentage"`
 	SpecialCalc  *string   `json:"specialCalc"`  // ROAS or null
+	Code         string    `json:"code"`         // optional identifier for formulas
+	Role         string    `json:"role"`         // revenue | cost | ratio | generic
+	Formula      *string   `json:"formula"`      // derived value, e.g. "omset / biaya"; null for entered KPIs
 	PointCapping string    `json:"pointCapping"` // uncapped | capped
 	CreatedAt    time.Time `json:"createdAt"`
 	UpdatedAt    time.Time `json:"updatedAt"`
 }
 
+// Period is a payroll month; history entries reference it so every spelling of a month
+// resolves to the same period
+type Period struct {
+	ID              uint            `json:"id" gorm:"primarykey"`
+	Year            int             `json:"year" gorm:"uniqueIndex:idx_period_year_month"`
+	Month           int             `json:"month" gorm:"uniqueIndex:idx_period_year_month"` // 1-12
+	Quarter         int             `json:"quarter"`
+	Status          string          `json:"status"`       // open | closed; closed locks history of every division
+	StatusReason    string          `json:"statusReason"` // reopen reason
+	StatusChangedBy string          `json:"statusChangedBy"`
+	StatusChangedAt *time.Time      `json:"statusChangedAt"`
+	Closures        []PeriodClosure `json:"closures,omitempty" gorm:"foreignKey:PeriodID"`
+	CreatedAt       time.Time       `json:"createdAt"`
+	UpdatedAt       time.Time       `json:"updatedAt"`
+}
+
+// PeriodClosure locks the history of one division in a period. ConfigVersionID is the
+// configuration in force when it was last closed.
+type PeriodClosure struct {
+	ID              uint       `json:"id" gorm:"primarykey"`
+	PeriodID        uint       `json:"periodId" gorm:"uniqueIndex:idx_period_closure"`
+	DivisionID      uint       `json:"divisionId" gorm:"uniqueIndex:idx_period_closure"`
+	Status          string     `json:"status"`       // open | closed
+	StatusReason    string     `json:"statusReason"` // reopen reason
+	StatusChangedBy string     `json:"statusChangedBy"`
+	StatusChangedAt *time.Time `json:"statusChangedAt"`
+	ConfigVersionID *uint      `json:"configVersionId"`
+	CreatedAt       time.Time  `json:"createdAt"`
+	UpdatedAt       time.Time  `json:"updatedAt"`
+}
+
+// DivisionBudget is the bonus pool of a division for one period. A payroll run whose bonuses
+// add up to more than Amount cuts them by Policy.
+type DivisionBudget struct {
+	ID         uint      `json:"id" gorm:"primarykey"`
+	DivisionID uint      `json:"divisionId" gorm:"uniqueIndex:idx_division_budget"`
+	PeriodID   uint      `json:"periodId" gorm:"uniqueIndex:idx_division_budget"`
+	Amount     float64   `json:"amount"`
+	Policy     string    `json:"policy"` // scale | clip
+	CreatedAt  time.Time `json:"createdAt"`
+	UpdatedAt  time.Time `json:"updatedAt"`
+}
+
+// PayrollRun records one application of a division's budget to the bonuses of a period
+type PayrollRun struct {
+	ID            uint      `json:"id" gorm:"primarykey"`
+	DivisionID    uint      `json:"divisionId" gorm:"index"`
+	PeriodID      uint      `json:"periodId" gorm:"index"`
+	Budget        *float64  `json:"budget"` // nil when the period has no budget
+	Policy        string    `json:"policy"`
+	Entries       int       `json:"entries"`
+	RawTotal      float64   `json:"rawTotal"`
+	AdjustedTotal float64   `json:"adjustedTotal"`
+	Factor        *float64  `json:"factor"`  // scale: share of every bonus that is paid
+	Ceiling       *float64  `json:"ceiling"` // clip: highest bonus that is paid
+	RunBy         string    `json:"runBy"`
+	Stale         bool      `json:"stale"`       // the budget or history changed since; run payroll again
+	StaleReason   string    `json:"staleReason"` // first change that made the run stale
+	CreatedAt     time.Time `json:"createdAt"`
+}
+
 type HistoryEntry struct {
-	ID             uint      `json:"id" gorm:"primarykey"`
-	DivisionID     uint      `json:"divisionId"`
-	EmployeeID     uint      `json:"employeeId"`
-	EmployeeName   string    `json:"employeeName"`
-	Date           time.Time `json:"date"`
-	PeriodMonth    string    `json:"periodMonth"`
-	PeriodYear     int       `json:"periodYear"`
-	TotalPoints    float64   `json:"totalPoints"`
-	Bonus          float64   `json:"bonus"`
-	ResultsJSON    string    `json:"resultsJson" gorm:"type:text"`
-	PDFDataURI     *string   `json:"pdfDataUri"`
-	CreatedAt      time.Time `json:"createdAt"`
-	UpdatedAt      time.Time `json:"updatedAt"`
+	ID              uint       `json:"id" gorm:"primarykey"`
+	DivisionID      uint       `json:"divisionId"`
+	EmployeeID      uint       `json:"employeeId"`
+	EmployeeName    string     `json:"employeeName"`
+	Date            time.Time  `json:"date"`
+	PeriodMonth     string     `json:"periodMonth"`
+	PeriodYear      int        `json:"periodYear"`
+	PeriodID        *uint      `json:"periodId" gorm:"index"`
+	TotalPoints     float64    `json:"totalPoints"`
+	Bonus           float64    `json:"bonus"`         // FinalBonus as calculated
+	AdjustedBonus   *float64   `json:"adjustedBonus"` // bonus after the division budget; nil until a payroll run
+	PayrollRunID    *uint      `json:"payrollRunId" gorm:"index"`
+	ResultsJSON     string     `json:"resultsJson" gorm:"type:text"`
+	InputsJSON      string     `json:"inputsJson" gorm:"type:text"` // realisasi inputs as typed, by KPI id
+	Grade           string     `json:"grade"`                       // employee grade the bonus base was resolved with; empty before it was stored
+	ConfigVersionID *uint      `json:"configVersionId"`
+	Status          string     `json:"status" gorm:"index"` // draft | submitted | approved | paid | rejected
+	StatusReason    string     `json:"statusReason"`        // rejection reason
+	StatusChangedBy string     `json:"statusChangedBy"`
+	StatusChangedAt *time.Time `json:"statusChangedAt"`
+	Revision        int        `json:"revision"`                // 1 for the original entry
+	PreviousID      *uint      `json:"previousId" gorm:"index"` // revision this one amends
+	IsCurrent       bool       `json:"isCurrent" gorm:"index"`  // latest revision, the one reporting uses
+	AmendReason     string     `json:"amendReason"`
+	CreatedAt       time.Time  `json:"createdAt"`
+	UpdatedAt       time.Time  `json:"updatedAt"`
+}
+
+// HistoryKpiResult is one KPI line of a history entry, stored as a row so scores can be
+// queried without decoding ResultsJSON. Name, platform, target and bobot are snapshots of the
+// configuration the entry was calculated with.
+type HistoryKpiResult struct {
+	ID        uint      `json:"id" gorm:"primarykey"`
+	HistoryID uint      `json:"historyId" gorm:"uniqueIndex:idx_history_kpi"`
+	KpiID     uint      `json:"kpiId" gorm:"uniqueIndex:idx_history_kpi;index"`
+	Name      string    `json:"name"`
+	Platform  string    `json:"platform"`
+	Target    float64   `json:"target"`
+	Bobot     float64   `json:"bobot"`
+	Realisasi float64   `json:"realisasi"`
+	Score     float64   `json:"score"` // achievement in percent
+	Poin      float64   `json:"poin"`
+	CreatedAt time.Time `json:"createdAt"`
 }
 
 // Calculation types used by /calculate endpoint
@@ -84,14 +210,22 @@ type KpiResultDetail struct {
 }
 
 type CalculationResult struct {
-	GrandTotalPoin      float64           `json:"grandTotalPoin"`
-	FinalBonus          float64           `json:"finalBonus"`
-	ActiveMultiplier    float64           `json:"activeMultiplier"`
-	KpiIndicator        map[string]any    `json:"kpiIndicator"`
-	OmsetIndicator      map[string]any    `json:"omsetIndicator"`
-	TotalOmsetRealisasi float64           `json:"totalOmsetRealisasi"`
-	TotalOmsetTarget    float64           `json:"totalOmsetTarget"`
-	Details             []KpiResultDetail `json:"details"`
+	GrandTotalPoin      float64            `json:"grandTotalPoin"`
+	FinalBonus          float64            `json:"finalBonus"`
+	ActiveMultiplier    float64            `json:"activeMultiplier"`
+	KpiIndicator        map[string]any     `json:"kpiIndicator"`
+	OmsetIndicator      map[string]any     `json:"omsetIndicator"`
+	TotalOmsetRealisasi float64            `json:"totalOmsetRealisasi"`
+	TotalOmsetTarget    float64            `json:"totalOmsetTarget"`
+	Details             []KpiResultDetail  `json:"details"`
+	SchemeMode          string             `json:"schemeMode"`
+	SchemeBreakdown     []SchemeTierDetail `json:"schemeBreakdown"`
+	UncappedBonus       float64            `json:"uncappedBonus"`
+	BonusCapped         bool               `json:"bonusCapped"`
+	BonusBase           float64            `json:"bonusBase"`       // rupiah per point used
+	BonusBaseSource     string             `json:"bonusBaseSource"` // default | division | grade
+	BonusFormula        string             `json:"bonusFormula"`    // formula that produced the bonus, if any
+	InputErrors         []KpiInputError    `json:"inputErrors"`     // realisasi inputs that could not be parsed, or a formula plan error
 }
 
 type CalculateRequest struct {
@@ -101,4 +235,57 @@ type CalculateRequest struct {
 	RealisasiInputs        map[uint]string `json:"realisasiInputs"`
 	BonusCalculationMethod string          `json:"bonusCalculationMethod"`
 	CustomCostKeywords     []string        `json:"customCostKeywords"`
-}
\ No newline at end of file
+	SchemeMode             string          `json:"schemeMode"`
+	BonusCap               *float64        `json:"bonusCap"`
+	BonusBaseRate          *float64        `json:"bonusBaseRate"`
+	BonusFormula           *string         `json:"bonusFormula"`
+	GradeRates             []GradeRate     `json:"gradeRates"`
+	Grade                  string          `json:"grade"`
+}
+
+// ConfigVersion is an immutable snapshot of the configuration CalculateBonus used for a division.
+// Snapshots live as long as their division: force-deleting it removes them with its history.
+type ConfigVersion struct {
+	ID         uint      `json:"id" gorm:"primarykey"`
+	DivisionID uint      `json:"divisionId" gorm:"index"`
+	Version    int       `json:"version"`
+	Checksum   string    `json:"checksum" gorm:"index"`
+	ConfigJSON string    `json:"-" gorm:"type:text"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+// User is an API account; managers are bound to a division and employees to their own Employee row
+type User struct {
+	ID           uint      `json:"id" gorm:"primarykey"`
+	Username     string    `json:"username" gorm:"uniqueIndex"`
+	PasswordHash string    `json:"-"`
+	Role         string    `json:"role"`       // admin | hr | manager | employee
+	DivisionID   *uint     `json:"divisionId"` // managed division (manager)
+	EmployeeID   *uint     `json:"employeeId"` // own employee record (employee)
+	CreatedAt    time.Time `json:"createdAt"`
+	UpdatedAt    time.Time `json:"updatedAt"`
+}
+
+// Session is a bearer token issued at login; only the token hash is stored
+type Session struct {
+	ID        uint   `gorm:"primarykey"`
+	TokenHash string `gorm:"uniqueIndex"`
+	UserID    uint   `gorm:"index"`
+	ExpiresAt time.Time
+	CreatedAt time.Time
+}
+
+// AuditLog records one create, update or delete of an audited entity
+type AuditLog struct {
+	ID         uint      `json:"id" gorm:"primarykey"`
+	ActorID    *uint     `json:"actorId" gorm:"index"`
+	Actor      string    `json:"actor"`                   // username, or "system" outside a request
+	Action     string    `json:"action" gorm:"index"`     // create | update | delete
+	EntityType string    `json:"entityType" gorm:"index"` // division | employee | kpi | scheme | indicator | grade_rate | pdf_template | history | period | period_closure | division_budget | payroll_run
+	EntityID   uint      `json:"entityId" gorm:"index"`
+	DivisionID *uint     `json:"divisionId" gorm:"index"`
+	BeforeJSON string    `json:"-" gorm:"type:text"`
+	AfterJSON  string    `json:"-" gorm:"type:text"`
+	DiffJSON   string    `json:"-" gorm:"type:text"`
+	CreatedAt  time.Time `json:"createdAt" gorm:"index"`
+}
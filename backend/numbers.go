@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Magnitude suffixes accepted after a number. A bare "M" is refused as ambiguous: Indonesian
+// reports use it for miliar (10^9), but it is just as often typed meaning million.
+var numberSuffixes = []struct {
+	suffix string
+	factor float64
+}{
+	{"triliun", 1e12}, {"miliar", 1e9}, {"milyar", 1e9}, {"juta", 1e6}, {"ribu", 1e3},
+	{"jt", 1e6}, {"rb", 1e3}, {"t", 1e12}, {"k", 1e3},
+}
+
+// KpiInputError reports a realisasi input that could not be parsed
+type KpiInputError struct {
+	KpiID uint   `json:"kpiId"`
+	Name  string `json:"name"`
+	Input string `json:"input"`
+	Error string `json:"error"`
+}
+
+// ParseNumber parses a realisasi input in Indonesian ("1.250.000,50") or international
+// ("1,250,000.50") notation, with an optional "Rp" prefix, "%" sign or magnitude suffix
+// (rb, jt, miliar, ...). An empty input is 0. A single separator followed by exactly three digits
+// is a thousands separator for currency values and a decimal point otherwise.
+func ParseNumber(input string, currency bool) (float64, error) {
+	s := strings.ToLower(strings.TrimSpace(strings.ReplaceAll(input, "\u00a0", " ")))
+	if s == "" { return 0, nil }
+	for _, prefix := range []string{"rp.", "rp", "idr"} {
+		if strings.HasPrefix(s, prefix) { s = strings.TrimSpace(s[len(prefix):]); break }
+	}
+	s = strings.TrimSpace(strings.TrimSuffix(s, "%"))
+	factor := 1.0
+	for _, sf := range numberSuffixes {
+		if strings.HasSuffix(s, sf.suffix) {
+			factor, s = sf.factor, strings.TrimSpace(strings.TrimSuffix(s, sf.suffix))
+			break
+		}
+	}
+	if factor == 1 && strings.HasSuffix(s, "m") { return 0, fmt.Errorf("ambiguous suffix in %q: write jt for juta or miliar", input) }
+	s = strings.ReplaceAll(s, " ", "")
+	negative := strings.HasPrefix(s, "-")
+	s = strings.TrimPrefix(strings.TrimPrefix(s, "-"), "+")
+	if s == "" { return 0, fmt.Errorf("invalid number %q", input) }
+
+	normalized, err := normalizeSeparators(s, currency)
+	if err != nil { return 0, fmt.Errorf("invalid number %q: %w", input, err) }
+	f, err := strconv.ParseFloat(normalized, 64)
+	if err != nil { return 0, fmt.Errorf("invalid number %q", input) }
+	if negative { f = -f }
+	return f * factor, nil
+}
+
+// normalizeSeparators rewrites digits with '.' and ',' separators to Go float syntax
+func normalizeSeparators(s string, preferGrouping bool) (string, error) {
+	for _, ch := range s {
+		if (ch < '0' || ch > '9') && ch != '.' && ch != ',' { return "", fmt.Errorf("unexpected %q", ch) }
+	}
+	dots, commas := strings.Count(s, "."), strings.Count(s, ",")
+	var decimal, group string
+	switch {
+	case dots == 0 && commas == 0:
+		return s, nil
+	case dots > 0 && commas > 0:
+		// Whichever separator comes last marks the decimals
+		decimal, group = ",", "."
+		if strings.LastIndex(s, ".") > strings.LastIndex(s, ",") { decimal, group = ".", "," }
+		if strings.Count(s, decimal) > 1 { return "", fmt.Errorf("more than one decimal separator") }
+	case dots > 1 || commas > 1:
+		group = "."
+		if commas > 1 { group = "," }
+	default:
+		sep := "."
+		if commas == 1 { sep = "," }
+		if frac := s[strings.Index(s, sep)+1:]; preferGrouping && len(frac) == 3 && strings.Index(s, sep) > 0 { group = sep } else { decimal = sep }
+	}
+
+	intPart, frac := s, ""
+	if decimal != "" {
+		i := strings.LastIndex(s, decimal)
+		intPart, frac = s[:i], s[i+1:]
+		if frac == "" { return "", fmt.Errorf("missing digits after decimal separator") }
+	}
+	if group != "" {
+		groups := strings.Split(intPart, group)
+		if groups[0] == "" || len(groups[0]) > 3 { return "", fmt.Errorf("misplaced thousands separator") }
+		for _, g := range groups[1:] {
+			if len(g) != 3 { return "", fmt.Errorf("misplaced thousands separator") }
+		}
+		intPart = strings.Join(groups, "")
+	}
+	if intPart == "" { intPart = "0" }
+	if frac == "" { return intPart, nil }
+	return intPart + "." + frac, nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseNumber(t *testing.T) {
+	tests := []struct {
+		input    string
+		currency bool
+		want     float64
+		err      string
+	}{
+		{input: "", want: 0},
+		{input: "  42  ", want: 42},
+		{input: "1.250.000,50", currency: true, want: 1250000.5},
+		{input: "1,250,000.50", currency: true, want: 1250000.5},
+		{input: "Rp 1.500.000", currency: true, want: 1500000},
+		{input: "Rp. 2.000", currency: true, want: 2000},
+		{input: "IDR 750", currency: true, want: 750},
+		{input: "1.000.000", want: 1000000},
+		// A single separator before exactly three digits groups thousands only for currency
+		{input: "1.500", currency: true, want: 1500},
+		{input: "1.500", want: 1.5},
+		{input: "1,500", currency: true, want: 1500},
+		{input: "1,500", want: 1.5},
+		{input: "1.50", currency: true, want: 1.5},
+		{input: "12,5%", want: 12.5},
+		{input: ",5", want: 0.5},
+		{input: "-1.000", currency: true, want: -1000},
+		{input: "+7", want: 7},
+		{input: "3rb", currency: true, want: 3000},
+		{input: "10k", currency: true, want: 10000},
+		{input: "2 juta", currency: true, want: 2000000},
+		{input: "1,5jt", currency: true, want: 1500000},
+		{input: "Rp 1,5 miliar", currency: true, want: 1500000000},
+		{input: "2 milyar", currency: true, want: 2000000000},
+		{input: "1t", currency: true, want: 1e12},
+		{input: "5m", currency: true, err: "ambiguous suffix"},
+		{input: "5 M", currency: true, err: "ambiguous suffix"},
+		{input: "1.2.3", currency: true, err: "misplaced thousands separator"},
+		{input: "1234.567", currency: true, err: "misplaced thousands separator"},
+		{input: "1.234,5.6", err: "more than one decimal separator"},
+		{input: "12,", err: "missing digits after decimal separator"},
+		{input: "abc", err: "unexpected"},
+		{input: "Rp", currency: true, err: "invalid number"},
+		{input: "-", err: "invalid number"},
+	}
+	for _, tt := range tests {
+		got, err := ParseNumber(tt.input, tt.currency)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) { t.Errorf("ParseNumber(%q, %v) = %v, %v; want error %q", tt.input, tt.currency, got, err, tt.err) }
+			continue
+		}
+		if err != nil || got != tt.want { t.Errorf("ParseNumber(%q, %v) = %v, %v; want %v", tt.input, tt.currency, got, err, tt.want) }
+	}
+}
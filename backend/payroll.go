@@ -0,0 +1,280 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Budget policies: how bonuses are cut when they add up to more than the budget
+const (
+	BudgetPolicyScale = "scale" // every bonus is paid the same share of itself
+	BudgetPolicyClip  = "clip"  // bonuses are paid up to a common ceiling, cutting only the largest
+)
+
+var validBudgetPolicies = map[string]bool{BudgetPolicyScale: true, BudgetPolicyClip: true}
+
+// BudgetRequest sets the bonus budget of a division for one period
+type BudgetRequest struct {
+	DivisionID  uint    `json:"divisionId"`
+	PeriodMonth string  `json:"periodMonth"`
+	PeriodYear  int     `json:"periodYear"`
+	Amount      float64 `json:"amount"`
+	Policy      string  `json:"policy"` // scale (default) | clip
+}
+
+// BudgetRow is a budget with the period it applies to
+type BudgetRow struct {
+	DivisionBudget
+	PeriodMonth string `json:"periodMonth"`
+	PeriodYear  int    `json:"periodYear"`
+	Month       int    `json:"-"`
+}
+
+// PayrollRunRequest applies the division's budget to the current history of a period. A dry
+// run only reports the adjusted bonuses.
+type PayrollRunRequest struct {
+	DivisionID  uint   `json:"divisionId"`
+	PeriodMonth string `json:"periodMonth"`
+	PeriodYear  int    `json:"periodYear"`
+	DryRun      bool   `json:"dryRun"`
+}
+
+// PayrollRunRow is the raw and adjusted bonus of one history entry
+type PayrollRunRow struct {
+	HistoryID     uint    `json:"historyId"`
+	EmployeeID    uint    `json:"employeeId"`
+	EmployeeName  string  `json:"employeeName"`
+	Status        string  `json:"status"`
+	Bonus         float64 `json:"bonus"`
+	AdjustedBonus float64 `json:"adjustedBonus"`
+}
+
+// PayrollRunResponse is a payroll run with the bonus of every entry it covered
+type PayrollRunResponse struct {
+	PayrollRun
+	DryRun bool            `json:"dryRun"`
+	Rows   []PayrollRunRow `json:"rows"`
+}
+
+// adjustBonuses fits bonuses into budget by policy and returns the share paid under scale or
+// the ceiling under clip. Bonuses that fit are paid in full and neither is returned.
+func adjustBonuses(bonuses []float64, budget float64, policy string) ([]float64, *float64, *float64) {
+	adjusted := append([]float64(nil), bonuses...)
+	total := 0.0
+	for _, b := range bonuses { total += b }
+	if total <= budget { return adjusted, nil, nil }
+
+	if policy == BudgetPolicyClip {
+		// Pay the smallest bonuses in full while an equal split of what is left still covers them
+		sorted := append([]float64(nil), bonuses...)
+		sort.Float64s(sorted)
+		remaining, ceiling := budget, 0.0
+		for i, b := range sorted {
+			if share := remaining / float64(len(sorted)-i); b > share { ceiling = share; break }
+			remaining -= b
+		}
+		for i, b := range adjusted { adjusted[i] = math.Min(b, ceiling) }
+		return adjusted, nil, &ceiling
+	}
+	factor := budget / total
+	for i := range adjusted { adjusted[i] *= factor }
+	return adjusted, &factor, nil
+}
+
+// paidBonus is what an entry was paid: its adjusted bonus, or the raw one before any payroll run
+func paidBonus(e HistoryEntry) float64 {
+	if e.AdjustedBonus != nil { return *e.AdjustedBonus }
+	return e.Bonus
+}
+
+// markPayrollStale flags the latest payroll run of the division's period once its budget or
+// payable history changes, since the adjusted bonuses it recorded no longer add up
+func markPayrollStale(tx *gorm.DB, divisionID, periodID uint, reason string) error {
+	return tx.Model(&PayrollRun{}).Where("id = (SELECT MAX(id) FROM payroll_runs WHERE division_id = ? AND period_id = ?) AND stale = ?", divisionID, periodID, false).
+		Updates(map[string]any{"stale": true, "stale_reason": reason}).Error
+}
+
+// planPayroll adjusts the bonuses of the division's current history in p to its budget.
+// Rejected entries are not paid: they are adjusted to 0 and left out of the totals.
+func planPayroll(tx *gorm.DB, divisionID uint, p Period) (PayrollRunResponse, []HistoryEntry, error) {
+	resp := PayrollRunResponse{PayrollRun: PayrollRun{DivisionID: divisionID, PeriodID: p.ID}, Rows: []PayrollRunRow{}}
+	var entries []HistoryEntry
+	if err := tx.Where("division_id = ? AND period_id = ? AND is_current = ?", divisionID, p.ID, true).Order("employee_name, id").Find(&entries).Error; err != nil { return resp, nil, err }
+	var budget DivisionBudget
+	err := tx.Where("division_id = ? AND period_id = ?", divisionID, p.ID).First(&budget).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) { return resp, nil, err }
+	hasBudget := err == nil
+
+	var bonuses []float64
+	for _, e := range entries {
+		if e.Status != StatusRejected { bonuses = append(bonuses, e.Bonus) }
+	}
+	adjusted := bonuses
+	if hasBudget {
+		resp.Budget, resp.Policy = &budget.Amount, budget.Policy
+		adjusted, resp.Factor, resp.Ceiling = adjustBonuses(bonuses, budget.Amount, budget.Policy)
+	}
+	i := 0
+	for _, e := range entries {
+		row := PayrollRunRow{HistoryID: e.ID, EmployeeID: e.EmployeeID, EmployeeName: e.EmployeeName, Status: e.Status, Bonus: e.Bonus}
+		if e.Status != StatusRejected {
+			row.AdjustedBonus = adjusted[i]
+			resp.Entries++
+			resp.RawTotal += e.Bonus
+			resp.AdjustedTotal += adjusted[i]
+			i++
+		}
+		resp.Rows = append(resp.Rows, row)
+	}
+	return resp, entries, nil
+}
+
+func registerPayrollRoutes(r *gin.RouterGroup) {
+	readers := requireRole(RoleAdmin, RoleHR, RoleManager)
+	admin := requireRole(RoleAdmin)
+
+	// GET /budgets?division_id=&year= lists division budgets; managers see their own division
+	r.GET("/budgets", readers, func(c *gin.Context) {
+		q := db.Model(&DivisionBudget{}).Joins("JOIN periods ON periods.id = division_budgets.period_id")
+		if u := currentUser(c); u.Role == RoleManager { q = q.Where("division_budgets.division_id = ?", u.DivisionID) }
+		if v := c.Query("division_id"); v != "" { q = q.Where("division_budgets.division_id = ?", v) }
+		if v := c.Query("year"); v != "" { q = q.Where("periods.year = ?", v) }
+		items := []BudgetRow{}
+		if err := q.Select("division_budgets.*, periods.year AS period_year, periods.month").Order("periods.year desc, periods.month desc, division_budgets.division_id").Scan(&items).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()}); return
+		}
+		for i := range items { items[i].PeriodMonth = Period{Month: items[i].Month}.MonthName() }
+		c.JSON(http.StatusOK, items)
+	})
+
+	// PUT /budgets {divisionId, periodMonth, periodYear, amount, policy} sets the budget of a
+	// division for a period, replacing an existing one. It takes effect on the next payroll run
+	// and marks the last one stale; a closed period is locked (423).
+	r.PUT("/budgets", admin, func(c *gin.Context) {
+		var req BudgetRequest
+		if err := c.ShouldBindJSON(&req); err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()}); return }
+		req.Policy = strings.TrimSpace(req.Policy)
+		if req.Policy == "" { req.Policy = BudgetPolicyScale }
+		if !validBudgetPolicies[req.Policy] { c.JSON(http.StatusBadRequest, gin.H{"error": "policy must be scale or clip"}); return }
+		if req.Amount < 0 { c.JSON(http.StatusBadRequest, gin.H{"error": "amount must not be negative"}); return }
+		p, err := newPeriod(req.PeriodMonth, req.PeriodYear)
+		if err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()}); return }
+		var division Division
+		if err := db.First(&division, req.DivisionID).Error; err != nil { respondLookupError(c, "division", err); return }
+
+		var budget DivisionBudget
+		err = db.WithContext(c).Transaction(func(tx *gorm.DB) error {
+			if err := ensurePeriod(tx, &p); err != nil { return err }
+			if err := checkPeriodOpen(tx, division.ID, p); err != nil { return err }
+			if err := markPayrollStale(tx, division.ID, p.ID, "budget changed"); err != nil { return err }
+			err := tx.Where("division_id = ? AND period_id = ?", division.ID, p.ID).First(&budget).Error
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				budget = DivisionBudget{DivisionID: division.ID, PeriodID: p.ID, Amount: req.Amount, Policy: req.Policy}
+				if err := tx.Create(&budget).Error; err != nil { return err }
+				return recordAudit(tx, AuditCreate, "division_budget", nil, &budget)
+			}
+			if err != nil { return err }
+			before := budget
+			budget.Amount, budget.Policy = req.Amount, req.Policy
+			if err := tx.Save(&budget).Error; err != nil { return err }
+			return recordAudit(tx, AuditUpdate, "division_budget", &before, &budget)
+		})
+		if err != nil { respondPeriodError(c, err); return }
+		c.JSON(http.StatusOK, BudgetRow{DivisionBudget: budget, PeriodMonth: p.MonthName(), PeriodYear: p.Year, Month: p.Month})
+	})
+
+	// DELETE /budgets/:id removes a budget, so the next payroll run pays bonuses as calculated;
+	// like PUT it marks the last run stale and is locked in a closed period
+	r.DELETE("/budgets/:id", admin, func(c *gin.Context) {
+		id, ok := parseID(c)
+		if !ok { return }
+		var budget DivisionBudget
+		if err := db.First(&budget, id).Error; err != nil { respondLookupError(c, "budget", err); return }
+		err := db.WithContext(c).Transaction(func(tx *gorm.DB) error {
+			var p Period
+			if err := tx.First(&p, budget.PeriodID).Error; err != nil { return err }
+			if err := checkPeriodOpen(tx, budget.DivisionID, p); err != nil { return err }
+			if err := markPayrollStale(tx, budget.DivisionID, p.ID, "budget deleted"); err != nil { return err }
+			if err := tx.Delete(&budget).Error; err != nil { return err }
+			return recordAudit(tx, AuditDelete, "division_budget", &budget, nil)
+		})
+		if err != nil { respondPeriodError(c, err); return }
+		c.Status(http.StatusNoContent)
+	})
+
+	// POST /payroll/run {divisionId, periodMonth, periodYear, dryRun} sums the bonus of the
+	// division's current history in the period and, when it exceeds the budget, scales or clips
+	// the bonuses by the budget's policy. Each entry keeps its calculated bonus and records the
+	// adjusted one; without a budget bonuses are paid as calculated. Running again replaces the
+	// adjusted bonuses, except that a paid bonus cannot change (409) and a closed period is
+	// locked (423).
+	r.POST("/payroll/run", admin, func(c *gin.Context) {
+		var req PayrollRunRequest
+		if err := c.ShouldBindJSON(&req); err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()}); return }
+		p, err := newPeriod(req.PeriodMonth, req.PeriodYear)
+		if err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()}); return }
+		var division Division
+		if err := db.First(&division, req.DivisionID).Error; err != nil { respondLookupError(c, "division", err); return }
+		if err := db.Where("year = ? AND month = ?", p.Year, p.Month).First(&p).Error; err != nil { respondLookupError(c, "period", err); return }
+
+		if req.DryRun {
+			resp, _, err := planPayroll(db, division.ID, p)
+			if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()}); return }
+			resp.DryRun = true
+			c.JSON(http.StatusOK, resp)
+			return
+		}
+		if err := checkPeriodOpen(db, division.ID, p); err != nil { respondPeriodError(c, err); return }
+
+		var resp PayrollRunResponse
+		var conflict []uint
+		err = db.WithContext(c).Transaction(func(tx *gorm.DB) error {
+			var entries []HistoryEntry
+			var err error
+			if resp, entries, err = planPayroll(tx, division.ID, p); err != nil { return err }
+			for i, e := range entries {
+				if e.Status == StatusPaid && math.Abs(paidBonus(e)-resp.Rows[i].AdjustedBonus) >= 0.005 { conflict = append(conflict, e.ID) }
+			}
+			if len(conflict) > 0 { return nil }
+
+			resp.RunBy = currentUser(c).Username
+			if err := tx.Create(&resp.PayrollRun).Error; err != nil { return err }
+			if err := recordAudit(tx, AuditCreate, "payroll_run", nil, &resp.PayrollRun); err != nil { return err }
+			for i, e := range entries {
+				before := e
+				e.AdjustedBonus, e.PayrollRunID = &resp.Rows[i].AdjustedBonus, &resp.ID
+				if err := tx.Model(&HistoryEntry{}).Where("id = ?", e.ID).Updates(map[string]any{"adjusted_bonus": *e.AdjustedBonus, "payroll_run_id": resp.ID}).Error; err != nil { return err }
+				if err := recordAudit(tx, AuditUpdate, "history", &before, &e); err != nil { return err }
+			}
+			return nil
+		})
+		if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()}); return }
+		if len(conflict) > 0 { c.JSON(http.StatusConflict, gin.H{"error": "payroll would change bonuses that are already paid", "historyIds": conflict}); return }
+		c.JSON(http.StatusCreated, resp)
+	})
+
+	// GET /payroll/runs?division_id=&period_month=&period_year= lists payroll runs, newest first
+	r.GET("/payroll/runs", readers, func(c *gin.Context) {
+		q := db.Model(&PayrollRun{})
+		if u := currentUser(c); u.Role == RoleManager { q = q.Where("division_id = ?", u.DivisionID) }
+		if v := c.Query("division_id"); v != "" { q = q.Where("division_id = ?", v) }
+		periods := db.Model(&Period{}).Select("id")
+		filtered := false
+		if v := c.Query("period_year"); v != "" { periods, filtered = periods.Where("year = ?", v), true }
+		if v := c.Query("period_month"); v != "" {
+			m, ok := parseMonth(v)
+			if !ok { c.JSON(http.StatusBadRequest, gin.H{"error": "invalid period_month"}); return }
+			periods, filtered = periods.Where("month = ?", m), true
+		}
+		if filtered { q = q.Where("period_id IN (?)", periods) }
+		items := []PayrollRun{}
+		if err := q.Order("id desc").Find(&items).Error; err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()}); return }
+		c.JSON(http.StatusOK, items)
+	})
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestAdjustBonuses(t *testing.T) {
+	tests := []struct {
+		name    string
+		bonuses []float64
+		budget  float64
+		policy  string
+		want    []float64
+		factor  *float64
+		ceiling *float64
+	}{
+		{name: "within budget", bonuses: []float64{100, 200}, budget: 300, policy: BudgetPolicyScale, want: []float64{100, 200}},
+		{name: "within budget clip", bonuses: []float64{100, 200}, budget: 1000, policy: BudgetPolicyClip, want: []float64{100, 200}},
+		{name: "no bonuses", bonuses: nil, budget: 0, policy: BudgetPolicyScale, want: nil},
+		{name: "scale", bonuses: []float64{100, 200, 300}, budget: 300, policy: BudgetPolicyScale, want: []float64{50, 100, 150}, factor: floatPtr(0.5)},
+		{name: "scale to zero", bonuses: []float64{100, 200}, budget: 0, policy: BudgetPolicyScale, want: []float64{0, 0}, factor: floatPtr(0)},
+		// 100 fits an equal third of 450; the other two split the remaining 350
+		{name: "clip largest", bonuses: []float64{300, 100, 200}, budget: 450, policy: BudgetPolicyClip, want: []float64{175, 100, 175}, ceiling: floatPtr(175)},
+		// An equal third of 300 is below the smallest bonus, so everyone gets 100
+		{name: "clip everyone", bonuses: []float64{150, 200, 400}, budget: 300, policy: BudgetPolicyClip, want: []float64{100, 100, 100}, ceiling: floatPtr(100)},
+		{name: "clip with zero bonus", bonuses: []float64{0, 50, 500}, budget: 250, policy: BudgetPolicyClip, want: []float64{0, 50, 200}, ceiling: floatPtr(200)},
+		{name: "clip to zero", bonuses: []float64{100, 200}, budget: 0, policy: BudgetPolicyClip, want: []float64{0, 0}, ceiling: floatPtr(0)},
+	}
+	same := func(a, b *float64) bool { return (a == nil) == (b == nil) && (a == nil || math.Abs(*a-*b) < 1e-9) }
+	for _, tt := range tests {
+		got, factor, ceiling := adjustBonuses(tt.bonuses, tt.budget, tt.policy)
+		if len(got) != len(tt.want) { t.Errorf("%s: adjusted = %v, want %v", tt.name, got, tt.want); continue }
+		total := 0.0
+		for i := range got {
+			if math.Abs(got[i]-tt.want[i]) > 1e-9 { t.Errorf("%s: adjusted = %v, want %v", tt.name, got, tt.want); break }
+			if got[i] > tt.bonuses[i] { t.Errorf("%s: adjusted %v above bonus %v", tt.name, got[i], tt.bonuses[i]) }
+			total += got[i]
+		}
+		if total > tt.budget+1e-9 { t.Errorf("%s: adjusted total %v exceeds budget %v", tt.name, total, tt.budget) }
+		if !same(factor, tt.factor) { t.Errorf("%s: factor = %v, want %v", tt.name, factor, tt.factor) }
+		if !same(ceiling, tt.ceiling) { t.Errorf("%s: ceiling = %v, want %v", tt.name, ceiling, tt.ceiling) }
+	}
+	bonuses := []float64{300, 100}
+	adjustBonuses(bonuses, 100, BudgetPolicyClip)
+	if bonuses[0] != 300 { t.Errorf("adjustBonuses modified its input: %v", bonuses) }
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-pdf/fpdf"
+	"gorm.io/gorm"
+)
+
+// Defaults used when a division has no PdfTemplate, matching the report the frontend used to render
+const (
+	defaultPdfCompany = "SASTRO GRUP"
+	defaultPdfTitle   = "Laporan Performa"
+	defaultPdfAccent  = "#3B82F6"
+)
+
+var hexColorPattern = regexp.MustCompile(`^#[0-9A-Fa-f]{6}$`)
+
+func preparePdfTemplate(id uint, t *PdfTemplate) error {
+	if err := requireDivision(t.DivisionID); err != nil { return err }
+	t.Title, t.CompanyName = strings.TrimSpace(t.Title), strings.TrimSpace(t.CompanyName)
+	t.AccentColor = strings.TrimSpace(t.AccentColor)
+	if t.AccentColor != "" && !hexColorPattern.MatchString(t.AccentColor) { return fmt.Errorf("invalid accentColor %q: use #RRGGBB", t.AccentColor) }
+	if t.PaperSize == "" { t.PaperSize = "A4" }
+	if t.PaperSize != "A4" && t.PaperSize != "Letter" { return fmt.Errorf("invalid paperSize %q", t.PaperSize) }
+	var cnt int64
+	if err := db.Model(&PdfTemplate{}).Where("division_id = ? AND id <> ?", t.DivisionID, id).Count(&cnt).Error; err != nil { return err }
+	if cnt > 0 { return errors.New("division already has a pdf template") }
+	return nil
+}
+
+// divisionPdfTemplate returns the division's template or the defaults
+func divisionPdfTemplate(divisionID uint) (PdfTemplate, error) {
+	var t PdfTemplate
+	err := db.Where("division_id = ?", divisionID).First(&t).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) { return PdfTemplate{DivisionID: divisionID, PaperSize: "A4"}, nil }
+	return t, err
+}
+
+// formatNumberID formats v with Indonesian separators ("1.234.567,89")
+func formatNumberID(v float64, decimals int) string {
+	s := strconv.FormatFloat(math.Abs(v), 'f', decimals, 64)
+	intPart, frac, _ := strings.Cut(s, ".")
+	var b strings.Builder
+	if v < 0 && strings.Trim(s, "0.") != "" { b.WriteByte('-') }
+	for i, ch := range intPart {
+		if i > 0 && (len(intPart)-i)%3 == 0 { b.WriteByte('.') }
+		b.WriteRune(ch)
+	}
+	if frac != "" { b.WriteString("," + frac) }
+	return b.String()
+}
+
+func formatRupiah(v float64) string { return "Rp " + formatNumberID(v, 0) }
+
+func formatDateID(t time.Time) string {
+	return fmt.Sprintf("%02d %s %d", t.Day(), idMonths[t.Month()-1], t.Year())
+}
+
+func parseHexColor(hex string) (int, int, int) {
+	if !hexColorPattern.MatchString(hex) { hex = defaultPdfAccent }
+	v, _ := strconv.ParseUint(hex[1:], 16, 32)
+	return int(v >> 16 & 0xff), int(v >> 8 & 0xff), int(v & 0xff)
+}
+
+// renderHistoryPDF lays out the performance report of one history entry using the
+// configuration it was calculated with
+func renderHistoryPDF(entry HistoryEntry, cfg DivisionConfig, tmpl PdfTemplate) ([]byte, error) {
+	var results CalculationResult
+	if entry.ResultsJSON != "" {
+		if err := json.Unmarshal([]byte(entry.ResultsJSON), &results); err != nil { return nil, err }
+	}
+	company, title, accent := tmpl.CompanyName, tmpl.Title, tmpl.AccentColor
+	if company == "" { company = defaultPdfCompany }
+	if title == "" { title = defaultPdfTitle }
+	if accent == "" { accent = defaultPdfAccent }
+	r, g, b := parseHexColor(accent)
+
+	pdf := fpdf.New("P", "mm", tmpl.PaperSize, "")
+	tr := pdf.UnicodeTranslatorFromDescriptor("")
+	pdf.SetMargins(14, 14, 14)
+	pdf.SetAutoPageBreak(true, 18)
+	generatedAt := time.Now()
+	pdf.SetFooterFunc(func() {
+		pdf.SetY(-14)
+		pdf.SetFont("Helvetica", "I", 8)
+		pdf.SetTextColor(120, 120, 120)
+		pdf.CellFormat(0, 5, tr(tmpl.FooterNote), "", 0, "L", false, 0, "")
+		pdf.SetX(14)
+		pdf.CellFormat(0, 5, fmt.Sprintf("Halaman %d/{nb}", pdf.PageNo()), "", 0, "R", false, 0, "")
+	})
+	pdf.AliasNbPages("")
+	pdf.AddPage()
+	pageWidth, _ := pdf.GetPageSize()
+	contentWidth := pageWidth - 28
+
+	// Header
+	pdf.SetFillColor(r, g, b)
+	pdf.Rect(14, 10, 10, 10, "F")
+	pdf.SetTextColor(0, 0, 0)
+	pdf.SetFont("Helvetica", "B", 18)
+	pdf.SetXY(28, 10)
+	pdf.CellFormat(0, 10, tr(company), "", 1, "L", false, 0, "")
+	pdf.SetFont("Helvetica", "", 10)
+	pdf.CellFormat(0, 6, tr("Diterbitkan pada: "+formatDateID(generatedAt)), "", 1, "L", false, 0, "")
+	pdf.Ln(6)
+
+	pdf.SetFont("Helvetica", "B", 16)
+	pdf.CellFormat(0, 8, tr(fmt.Sprintf("%s - Divisi %s", title, cfg.Division.Name)), "", 1, "L", false, 0, "")
+	pdf.SetFont("Helvetica", "", 11)
+	info := [][2]string{
+		{"Staff", entry.EmployeeName},
+		{"Periode", strings.TrimSpace(fmt.Sprintf("%s %d", entry.PeriodMonth, entry.PeriodYear))},
+		{"Tanggal", formatDateID(entry.Date)},
+		{"Status", entry.Status},
+	}
+	if entry.ConfigVersionID != nil { info = append(info, [2]string{"Versi Konfigurasi", fmt.Sprintf("#%d", *entry.ConfigVersionID)}) }
+	for _, kv := range info {
+		pdf.CellFormat(40, 6, tr(kv[0]), "", 0, "L", false, 0, "")
+		pdf.CellFormat(0, 6, tr(": "+kv[1]), "", 1, "L", false, 0, "")
+	}
+	if tmpl.HeaderNote != "" {
+		pdf.Ln(2)
+		pdf.SetFont("Helvetica", "I", 10)
+		pdf.MultiCell(0, 5, tr(tmpl.HeaderNote), "", "L", false)
+	}
+	pdf.Ln(4)
+
+	// Summary
+	nonSales := cfg.Division.BonusCalculationMethod == "NON_SALES"
+	summary := [][2]string{
+		{"Grand Total Poin", formatNumberID(results.GrandTotalPoin, 3)},
+		{"Indikator KPI", fmt.Sprint(results.KpiIndicator["name"])},
+	}
+	if !nonSales {
+		label := "Indikator Omset"
+		if cfg.Division.BonusCalculationMethod == "POINTS_BASED" { label = "Indikator Performa" }
+		summary = append(summary,
+			[2]string{label, fmt.Sprint(results.OmsetIndicator["name"])},
+			[2]string{"Multiplier", formatNumberID(results.ActiveMultiplier, 2)},
+		)
+		if results.BonusBase > 0 { summary = append(summary, [2]string{"Basis Bonus per Poin", formatRupiah(results.BonusBase)}) }
+		if results.BonusCapped { summary = append(summary, [2]string{"Bonus Sebelum Batas", formatRupiah(results.UncappedBonus)}) }
+		// Once a payroll run fits the bonus to the division budget, that is what gets paid
+		if entry.AdjustedBonus != nil { summary = append(summary, [2]string{"Bonus Terhitung", formatRupiah(entry.Bonus)}) }
+		summary = append(summary, [2]string{"Bonus Final", formatRupiah(paidBonus(entry))})
+	}
+	pdf.SetFont("Helvetica", "", 11)
+	for _, kv := range summary {
+		pdf.CellFormat(60, 7, tr(kv[0]), "B", 0, "L", false, 0, "")
+		pdf.CellFormat(contentWidth-60, 7, tr(kv[1]), "B", 1, "R", false, 0, "")
+	}
+	pdf.Ln(6)
+
+	// KPI detail per platform
+	if !tmpl.HideKpiDetails {
+		details := map[uint]KpiResultDetail{}
+		for _, d := range results.Details { details[d.ID] = d }
+		var platforms []string
+		byPlatform := map[string][]KpiConfig{}
+		for _, k := range cfg.KpiConfigs {
+			if _, ok := byPlatform[k.Platform]; !ok { platforms = append(platforms, k.Platform) }
+			byPlatform[k.Platform] = append(byPlatform[k.Platform], k)
+		}
+		widths := []float64{contentWidth * 0.34, contentWidth * 0.1, contentWidth * 0.16, contentWidth * 0.16, contentWidth * 0.12, contentWidth * 0.12}
+		head := []string{"KPI", "Bobot (%)", "Target", "Realisasi", "Score (%)", "Poin"}
+		for _, platform := range platforms {
+			pdf.SetFont("Helvetica", "B", 13)
+			pdf.CellFormat(0, 8, tr(platform), "", 1, "L", false, 0, "")
+			pdf.SetFont("Helvetica", "B", 9)
+			pdf.SetFillColor(r, g, b)
+			pdf.SetTextColor(255, 255, 255)
+			for i, h := range head { pdf.CellFormat(widths[i], 7, tr(h), "1", 0, "C", true, 0, "") }
+			pdf.Ln(-1)
+			pdf.SetTextColor(0, 0, 0)
+			pdf.SetFont("Helvetica", "", 9)
+			for _, k := range byPlatform[platform] {
+				target := formatNumberID(k.Target, 2)
+				if k.IsCurrency { target = formatRupiah(k.Target) } else if k.IsPercentage { target += "%" }
+				realisasi, score, poin := "-", "-", "-"
+				if d, ok := details[k.ID]; ok {
+					realisasi = formatNumberID(d.Realisasi, 2)
+					if k.IsCurrency { realisasi = formatRupiah(d.Realisasi) }
+					score, poin = formatNumberID(d.Score, 2), formatNumberID(d.Poin, 3)
+				}
+				row := []string{k.Name, formatNumberID(k.Bobot, 0), target, realisasi, score, poin}
+				for i, cell := range row {
+					align := "R"
+					if i == 0 { align = "L" }
+					pdf.CellFormat(widths[i], 6, tr(cell), "1", 0, align, false, 0, "")
+				}
+				pdf.Ln(-1)
+			}
+			pdf.Ln(5)
+		}
+	}
+
+	if tmpl.ShowSchemeBreakdown && len(results.SchemeBreakdown) > 0 {
+		pdf.SetFont("Helvetica", "B", 13)
+		pdf.CellFormat(0, 8, tr("Rincian Skema Bonus ("+results.SchemeMode+")"), "", 1, "L", false, 0, "")
+		pdf.SetFont("Helvetica", "", 9)
+		for _, t := range results.SchemeBreakdown {
+			line := fmt.Sprintf("%s: ambang %s, multiplier %s x bobot %s = %s", t.Name, formatNumberID(t.Threshold, 0), formatNumberID(t.Multiplier, 2), formatNumberID(t.Weight, 3), formatNumberID(t.Contribution, 3))
+			pdf.CellFormat(0, 6, tr(line), "", 1, "L", false, 0, "")
+		}
+		pdf.Ln(5)
+	}
+
+	if labels := splitCSV(tmpl.SignatureLabels); len(labels) > 0 {
+		pdf.Ln(8)
+		w := contentWidth / float64(len(labels))
+		pdf.SetFont("Helvetica", "", 10)
+		for _, l := range labels { pdf.CellFormat(w, 6, tr(l), "", 0, "C", false, 0, "") }
+		pdf.Ln(24)
+		for range labels { pdf.CellFormat(w, 6, "(____________________)", "", 0, "C", false, 0, "") }
+		pdf.Ln(-1)
+	}
+
+	var buf bytes.Buffer
+	if err := pdf.Output(&buf); err != nil { return nil, err }
+	return buf.Bytes(), nil
+}
+
+// exportHistoryPdfBlobs writes every PDF stored in pdf_data_uri to dir as history-<id>.pdf;
+// a value that is not a base64 data URI is kept as history-<id>.txt
+func exportHistoryPdfBlobs(dir string) (int, error) {
+	rows, err := db.Table("history_entries").Select("id, pdf_data_uri").Where("pdf_data_uri IS NOT NULL AND pdf_data_uri <> ''").Rows()
+	if err != nil { return 0, err }
+	defer rows.Close()
+	if err := os.MkdirAll(dir, 0o750); err != nil { return 0, err }
+	n := 0
+	for rows.Next() {
+		var id uint
+		var uri string
+		if err := rows.Scan(&id, &uri); err != nil { return n, err }
+		data, ext := []byte(uri), ".txt"
+		if i := strings.Index(uri, ";base64,"); strings.HasPrefix(uri, "data:") && i >= 0 {
+			if b, err := base64.StdEncoding.DecodeString(uri[i+len(";base64,"):]); err == nil { data, ext = b, ".pdf" }
+		}
+		if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("history-%d%s", id, ext)), data, 0o640); err != nil { return n, err }
+		n++
+	}
+	return n, rows.Err()
+}
+
+// dropHistoryPdfBlobs removes the pdf_data_uri column that held client-rendered PDFs; reports
+// are generated on request from GET /history/:id/pdf instead. Those PDFs are the only copy of
+// what was handed out, so the column is only dropped when DROP_HISTORY_PDFS=true, and only
+// after every one of them was written to HISTORY_PDF_BACKUP_DIR (default history-pdf-backup).
+func dropHistoryPdfBlobs() {
+	if !db.Migrator().HasColumn(&HistoryEntry{}, "pdf_data_uri") { return }
+	if os.Getenv("DROP_HISTORY_PDFS") != "true" { log.Printf("History still holds stored PDFs in pdf_data_uri; set DROP_HISTORY_PDFS=true to back them up and drop the column"); return }
+	dir := os.Getenv("HISTORY_PDF_BACKUP_DIR")
+	if dir == "" { dir = "history-pdf-backup" }
+	n, err := exportHistoryPdfBlobs(dir)
+	if err != nil { log.Printf("Failed to back up stored history PDFs, keeping pdf_data_uri: %v", err); return }
+	if err := db.Migrator().DropColumn(&HistoryEntry{}, "pdf_data_uri"); err != nil { log.Printf("Failed to drop history pdf_data_uri column: %v", err); return }
+	log.Printf("Backed up %d stored history PDFs to %s and dropped pdf_data_uri; PDFs are now generated on request", n, dir)
+}
+
+func registerPdfRoutes(r *gin.RouterGroup) {
+	admin := requireRole(RoleAdmin)
+	templateHook := auditHook[PdfTemplate]("pdf_template")
+	r.GET("/pdf-templates", func(c *gin.Context) { listRecords[PdfTemplate](c, true) })
+	r.POST("/pdf-templates", admin, func(c *gin.Context) { createRecord(c, preparePdfTemplate, templateHook) })
+	r.PUT("/pdf-templates/:id", admin, func(c *gin.Context) { updateRecord(c, "pdf template", false, preparePdfTemplate, templateHook) })
+	r.PATCH("/pdf-templates/:id", admin, func(c *gin.Context) { updateRecord(c, "pdf template", true, preparePdfTemplate, templateHook) })
+	r.DELETE("/pdf-templates/:id", admin, func(c *gin.Context) { deleteRecord(c, "pdf template", templateHook) })
+
+	// GET /history/:id/pdf renders the report with the division's template and the pinned configuration
+	r.GET("/history/:id/pdf", func(c *gin.Context) {
+		id, ok := parseID(c)
+		if !ok { return }
+		var entry HistoryEntry
+		if err := db.First(&entry, id).Error; err != nil { respondLookupError(c, "history entry", err); return }
+		if !currentUser(c).canReadHistory(entry) { c.JSON(http.StatusNotFound, gin.H{"error": "history entry not found"}); return }
+		cfg, err := historyConfig(entry)
+		if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()}); return }
+		tmpl, err := divisionPdfTemplate(entry.DivisionID)
+		if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()}); return }
+		data, err := renderHistoryPDF(entry, cfg, tmpl)
+		if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()}); return }
+		filename := fmt.Sprintf("Laporan Performa - %s - %s %s %d.pdf", cfg.Division.Name, entry.EmployeeName, entry.PeriodMonth, entry.PeriodYear)
+		c.Header("Content-Disposition", fmt.Sprintf("inline; filename=%q", strings.ReplaceAll(filename, `"`, "")))
+		c.Data(http.StatusOK, "application/pdf", data)
+	})
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Period statuses
+const (
+	PeriodOpen   = "open"
+	PeriodClosed = "closed"
+)
+
+const (
+	minPeriodYear = 2000
+	maxPeriodYear = 2100
+)
+
+var idMonths = []string{"Januari", "Februari", "Maret", "April", "Mei", "Juni", "Juli", "Agustus", "September", "Oktober", "November", "Desember"}
+
+// Month names accepted in a period, Indonesian and English, lower case
+var monthNames = map[string]int{
+	"januari": 1, "january": 1, "jan": 1,
+	"februari": 2, "february": 2, "feb": 2,
+	"maret": 3, "march": 3, "mar": 3,
+	"april": 4, "apr": 4,
+	"mei": 5, "may": 5,
+	"juni": 6, "june": 6, "jun": 6,
+	"juli": 7, "july": 7, "jul": 7,
+	"agustus": 8, "august": 8, "agu": 8, "aug": 8,
+	"september": 9, "sep": 9, "sept": 9,
+	"oktober": 10, "october": 10, "okt": 10, "oct": 10,
+	"november": 11, "nov": 11,
+	"desember": 12, "december": 12, "des": 12, "dec": 12,
+}
+
+// parseMonth resolves "Januari", "January", "Jan", "01" or "1" to 1..12
+func parseMonth(s string) (int, bool) {
+	v := strings.ToLower(strings.TrimSpace(s))
+	if m, ok := monthNames[v]; ok { return m, true }
+	if m, err := strconv.Atoi(v); err == nil && m >= 1 && m <= 12 { return m, true }
+	return 0, false
+}
+
+// periodKey orders periods as year*100 + month; an unrecognised month counts as 0
+func periodKey(year int, month string) int {
+	m, _ := parseMonth(month)
+	return year*100 + m
+}
+
+// parsePeriodParam parses a "YYYY-MM" query parameter to its period key
+func parsePeriodParam(v string) (int, error) {
+	y, m, ok := strings.Cut(strings.TrimSpace(v), "-")
+	year, err := strconv.Atoi(y)
+	if !ok || err != nil { return 0, fmt.Errorf("invalid period %q; use YYYY-MM", v) }
+	month, ok := parseMonth(m)
+	if !ok { return 0, fmt.Errorf("invalid period %q; use YYYY-MM", v) }
+	return year*100 + month, nil
+}
+
+// periodKeySQL is the SQL form of periodKey for a history row, read from its Period
+const periodKeySQL = "(SELECT periods.year * 100 + periods.month FROM periods WHERE periods.id = history_entries.period_id)"
+
+// newPeriod validates a month (any spelling parseMonth accepts) and year into an unsaved period
+func newPeriod(month string, year int) (Period, error) {
+	m, ok := parseMonth(month)
+	if !ok { return Period{}, fmt.Errorf("invalid periodMonth %q; use a month name or 1-12", month) }
+	if year < minPeriodYear || year > maxPeriodYear { return Period{}, fmt.Errorf("periodYear must be between %d and %d", minPeriodYear, maxPeriodYear) }
+	return Period{Year: year, Month: m, Quarter: (m + 2) / 3, Status: PeriodOpen}, nil
+}
+
+// MonthName is the canonical month stored on history entries
+func (p Period) MonthName() string { return idMonths[p.Month-1] }
+
+// ensurePeriod loads the period row for p's year and month, creating it when missing
+func ensurePeriod(tx *gorm.DB, p *Period) error {
+	return tx.Where(Period{Year: p.Year, Month: p.Month}).Attrs(Period{Quarter: p.Quarter, Status: PeriodOpen}).FirstOrCreate(p).Error
+}
+
+// filterHistoryPeriod applies the period parameters shared by every history listing:
+// period_month (any accepted spelling), period_year, quarter and the from/to range (YYYY-MM).
+// On an invalid parameter it responds 400 and returns false.
+func filterHistoryPeriod(c *gin.Context, q *gorm.DB) (*gorm.DB, bool) {
+	periods := db.Model(&Period{}).Select("id")
+	filtered := false
+	if v := c.Query("period_month"); v != "" {
+		m, ok := parseMonth(v)
+		if !ok { c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid period_month %q", v)}); return q, false }
+		periods, filtered = periods.Where("month = ?", m), true
+	}
+	if v := c.Query("period_year"); v != "" {
+		y, err := strconv.Atoi(v)
+		if err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": "invalid period_year"}); return q, false }
+		periods, filtered = periods.Where("year = ?", y), true
+	}
+	if v := c.Query("quarter"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 4 { c.JSON(http.StatusBadRequest, gin.H{"error": "quarter must be 1-4"}); return q, false }
+		periods, filtered = periods.Where("quarter = ?", n), true
+	}
+	for param, op := range map[string]string{"from": ">=", "to": "<="} {
+		v := c.Query(param)
+		if v == "" { continue }
+		key, err := parsePeriodParam(v)
+		if err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": param + ": " + err.Error()}); return q, false }
+		periods, filtered = periods.Where("year * 100 + month "+op+" ?", key), true
+	}
+	if filtered { q = q.Where("period_id IN (?)", periods) }
+	return q, true
+}
+
+// normalizeHistoryPeriods links history saved before periods existed to its Period and rewrites
+// the month to its canonical name. Rows with an unrecognised month stay unlinked and are listed,
+// with any duplicates the normalised months expose, by GET /periods/unresolved.
+func normalizeHistoryPeriods() {
+	var entries []HistoryEntry
+	if err := db.Select("id, period_month, period_year").Where("period_id IS NULL").Find(&entries).Error; err != nil { log.Printf("Failed to load history for period migration: %v", err); return }
+	linked := 0
+	for _, e := range entries {
+		p, err := newPeriod(e.PeriodMonth, e.PeriodYear)
+		if err != nil { log.Printf("History entry %d left without period: %v", e.ID, err); continue }
+		if err := ensurePeriod(db, &p); err != nil { log.Printf("Failed to create period %d-%02d: %v", p.Year, p.Month, err); return }
+		if err := db.Model(&HistoryEntry{}).Where("id = ?", e.ID).Updates(map[string]any{"period_id": p.ID, "period_month": p.MonthName()}).Error; err != nil {
+			log.Printf("Failed to link history entry %d to its period: %v", e.ID, err); return
+		}
+		linked++
+	}
+	if len(entries) > 0 { log.Printf("Linked %d history entries to periods", linked) }
+
+	// Spellings like "Januari" and "01" used to slip past duplicate detection
+	dups, err := historyPeriodDuplicates(db.Model(&HistoryEntry{}))
+	if err != nil { log.Printf("Failed to check history for duplicates: %v", err); return }
+	for _, d := range dups {
+		log.Printf("Employee %d of division %d has %d history entries for period %d; resolve them manually", d.EmployeeID, d.DivisionID, len(d.HistoryIDs), d.PeriodID)
+	}
+	// At most one current entry per employee and period; the index can only be built once
+	// every duplicate is resolved, until then createHistory's check is the only guard
+	if len(dups) > 0 { log.Printf("Unique index on current history per period not created: %d duplicates remain", len(dups)); return }
+	if err := db.Exec("CREATE UNIQUE INDEX IF NOT EXISTS idx_history_current_period ON history_entries (division_id, employee_id, period_id) WHERE is_current").Error; err != nil {
+		log.Printf("Failed to create unique index on current history per period: %v", err)
+	}
+}
+
+// HistoryPeriodDuplicate is an employee with more than one current history entry in a period
+type HistoryPeriodDuplicate struct {
+	DivisionID uint   `json:"divisionId"`
+	EmployeeID uint   `json:"employeeId"`
+	PeriodID   uint   `json:"periodId"`
+	HistoryIDs []uint `json:"historyIds"`
+}
+
+// historyPeriodDuplicates finds duplicate current history among the rows of q
+func historyPeriodDuplicates(q *gorm.DB) ([]HistoryPeriodDuplicate, error) {
+	var rows []HistoryEntry
+	err := q.Select("id, division_id, employee_id, period_id").Where("period_id IS NOT NULL AND is_current = ?", true).
+		Where("(division_id, employee_id, period_id) IN (?)", db.Model(&HistoryEntry{}).Select("division_id, employee_id, period_id").
+			Where("period_id IS NOT NULL AND is_current = ?", true).Group("division_id, employee_id, period_id").Having("COUNT(*) > 1")).
+		Order("division_id, employee_id, period_id, id").Find(&rows).Error
+	dups := []HistoryPeriodDuplicate{}
+	for _, e := range rows {
+		n := len(dups)
+		if n == 0 || dups[n-1].DivisionID != e.DivisionID || dups[n-1].EmployeeID != e.EmployeeID || dups[n-1].PeriodID != *e.PeriodID {
+			dups = append(dups, HistoryPeriodDuplicate{DivisionID: e.DivisionID, EmployeeID: e.EmployeeID, PeriodID: *e.PeriodID})
+			n++
+		}
+		dups[n-1].HistoryIDs = append(dups[n-1].HistoryIDs, e.ID)
+	}
+	return dups, err
+}
+
+// UnlinkedHistory is a history entry whose month could not be read, so no period filter or
+// analytics includes it
+type UnlinkedHistory struct {
+	ID           uint   `json:"id"`
+	DivisionID   uint   `json:"divisionId"`
+	EmployeeID   uint   `json:"employeeId"`
+	EmployeeName string `json:"employeeName"`
+	PeriodMonth  string `json:"periodMonth"`
+	PeriodYear   int    `json:"periodYear"`
+	Error        string `json:"error"`
+}
+
+func registerPeriodRoutes(r *gin.RouterGroup) {
+	// GET /periods?year=&status= lists the periods history has been saved for, newest first,
+	// with their per-division closures
+	r.GET("/periods", func(c *gin.Context) {
+		q := db.Model(&Period{}).Preload("Closures")
+		if v := c.Query("year"); v != "" { q = q.Where("year = ?", v) }
+		if v := c.Query("status"); v != "" { q = q.Where("status = ?", v) }
+		var items []Period
+		if err := q.Order("year desc, month desc").Find(&items).Error; err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()}); return }
+		c.JSON(http.StatusOK, items)
+	})
+
+	// GET /periods/unresolved lists the history the period migration could not sort out: entries
+	// without a period and employees with several current entries in one period. Fix them by
+	// amending or deleting the entries; the unique index follows on the next start.
+	r.GET("/periods/unresolved", requireRole(RoleAdmin, RoleHR, RoleManager), func(c *gin.Context) {
+		var entries []HistoryEntry
+		if err := scopeHistory(db.Model(&HistoryEntry{}), currentUser(c)).Select("id, division_id, employee_id, employee_name, period_month, period_year").
+			Where("period_id IS NULL").Order("id").Find(&entries).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()}); return
+		}
+		unlinked := make([]UnlinkedHistory, 0, len(entries))
+		for _, e := range entries {
+			u := UnlinkedHistory{ID: e.ID, DivisionID: e.DivisionID, EmployeeID: e.EmployeeID, EmployeeName: e.EmployeeName, PeriodMonth: e.PeriodMonth, PeriodYear: e.PeriodYear}
+			if _, err := newPeriod(e.PeriodMonth, e.PeriodYear); err != nil { u.Error = err.Error() }
+			unlinked = append(unlinked, u)
+		}
+		dups, err := historyPeriodDuplicates(scopeHistory(db.Model(&HistoryEntry{}), currentUser(c)))
+		if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()}); return }
+		c.JSON(http.StatusOK, gin.H{"unlinked": unlinked, "duplicates": dups})
+	})
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+var (
+	errPeriodClosed    = errors.New("period is closed")
+	errPeriodUnchanged = errors.New("period is already")
+)
+
+// PeriodStatusRequest closes or reopens a period for one division, or for every division when
+// DivisionID is omitted
+type PeriodStatusRequest struct {
+	PeriodMonth string `json:"periodMonth"`
+	PeriodYear  int    `json:"periodYear"`
+	DivisionID  *uint  `json:"divisionId"`
+	Reason      string `json:"reason"` // required to reopen
+}
+
+// checkPeriodOpen returns an errPeriodClosed error when history of the division in p's year
+// and month is locked, either for every division or for this one
+func checkPeriodOpen(tx *gorm.DB, divisionID uint, p Period) error {
+	var stored Period
+	err := tx.Where("year = ? AND month = ?", p.Year, p.Month).First(&stored).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) { return nil }
+	if err != nil { return err }
+	if stored.Status == PeriodClosed { return fmt.Errorf("%w: %s %d is closed", errPeriodClosed, stored.MonthName(), stored.Year) }
+	var cnt int64
+	if err := tx.Model(&PeriodClosure{}).Where("period_id = ? AND division_id = ? AND status = ?", stored.ID, divisionID, PeriodClosed).Count(&cnt).Error; err != nil { return err }
+	if cnt > 0 { return fmt.Errorf("%w: %s %d is closed for this division", errPeriodClosed, stored.MonthName(), stored.Year) }
+	return nil
+}
+
+// checkHistoryOpen is checkPeriodOpen for a stored entry; entries without a period are never locked
+func checkHistoryOpen(tx *gorm.DB, entry HistoryEntry) error {
+	if entry.PeriodID == nil { return nil }
+	var p Period
+	if err := tx.First(&p, *entry.PeriodID).Error; err != nil { return err }
+	return checkPeriodOpen(tx, entry.DivisionID, p)
+}
+
+// respondPeriodError answers 423 Locked for a closed period and 500 for anything else
+func respondPeriodError(c *gin.Context, err error) {
+	if errors.Is(err, errPeriodClosed) { c.JSON(http.StatusLocked, gin.H{"error": err.Error()}); return }
+	c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+}
+
+// pinPeriodConfig snapshots the division's configuration and pins history of the period that
+// still follows the current configuration to it, so later config edits cannot change it
+func pinPeriodConfig(tx *gorm.DB, divisionID, periodID uint) (ConfigVersion, error) {
+	version, err := snapshotDivisionConfig(tx, divisionID)
+	if err != nil { return version, err }
+	err = tx.Model(&HistoryEntry{}).Where("division_id = ? AND period_id = ? AND config_version_id IS NULL", divisionID, periodID).Update("config_version_id", version.ID).Error
+	return version, err
+}
+
+// setPeriodStatus closes or reopens p for a division, or for every division when divisionID is nil
+func setPeriodStatus(tx *gorm.DB, p *Period, divisionID *uint, status, reason, actor string) error {
+	now := time.Now()
+	if divisionID == nil {
+		if p.Status == status { return fmt.Errorf("%w %s", errPeriodUnchanged, status) }
+		if status == PeriodClosed {
+			var divisions []uint
+			if err := tx.Model(&HistoryEntry{}).Where("period_id = ?", p.ID).Distinct().Pluck("division_id", &divisions).Error; err != nil { return err }
+			for _, id := range divisions {
+				if _, err := pinPeriodConfig(tx, id, p.ID); err != nil { return err }
+			}
+		}
+		before := *p
+		p.Status, p.StatusReason, p.StatusChangedBy, p.StatusChangedAt = status, reason, actor, &now
+		if err := tx.Save(p).Error; err != nil { return err }
+		return recordAudit(tx, AuditUpdate, "period", &before, p)
+	}
+
+	var closure PeriodClosure
+	err := tx.Where("period_id = ? AND division_id = ?", p.ID, *divisionID).First(&closure).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) { return err }
+	existed := err == nil
+	if !existed { closure = PeriodClosure{PeriodID: p.ID, DivisionID: *divisionID, Status: PeriodOpen} }
+	if closure.Status == status { return fmt.Errorf("%w %s for this division", errPeriodUnchanged, status) }
+	before := closure
+	closure.Status, closure.StatusReason, closure.StatusChangedBy, closure.StatusChangedAt = status, reason, actor, &now
+	if status == PeriodClosed {
+		version, err := pinPeriodConfig(tx, *divisionID, p.ID)
+		if err != nil { return err }
+		closure.ConfigVersionID = &version.ID
+	}
+	if err := tx.Save(&closure).Error; err != nil { return err }
+	p.Closures = []PeriodClosure{closure}
+	if !existed { return recordAudit(tx, AuditCreate, "period_closure", nil, &closure) }
+	return recordAudit(tx, AuditUpdate, "period_closure", &before, &closure)
+}
+
+func registerPeriodLockRoutes(r *gin.RouterGroup) {
+	// POST /periods/close and /periods/reopen {periodMonth, periodYear, divisionId, reason}.
+	// A closed period refuses new and deleted history with 423; reopening requires a reason,
+	// which is kept on the period and in the audit log.
+	for path, status := range map[string]string{"/periods/close": PeriodClosed, "/periods/reopen": PeriodOpen} {
+		status := status
+		r.POST(path, requireRole(RoleAdmin), func(c *gin.Context) {
+			var req PeriodStatusRequest
+			if err := c.ShouldBindJSON(&req); err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()}); return }
+			req.Reason = strings.TrimSpace(req.Reason)
+			if status == PeriodOpen && req.Reason == "" { c.JSON(http.StatusBadRequest, gin.H{"error": "reason is required to reopen a period"}); return }
+			p, err := newPeriod(req.PeriodMonth, req.PeriodYear)
+			if err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()}); return }
+			if req.DivisionID != nil {
+				var division Division
+				if err := db.First(&division, *req.DivisionID).Error; err != nil { respondLookupError(c, "division", err); return }
+			}
+
+			err = db.WithContext(c).Transaction(func(tx *gorm.DB) error {
+				if err := ensurePeriod(tx, &p); err != nil { return err }
+				return setPeriodStatus(tx, &p, req.DivisionID, status, req.Reason, currentUser(c).Username)
+			})
+			if errors.Is(err, errPeriodUnchanged) { c.JSON(http.StatusConflict, gin.H{"error": err.Error()}); return }
+			if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()}); return }
+			c.JSON(http.StatusOK, p)
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestPeriodCloseReopen(t *testing.T) {
+	s := newTestServer(t)
+	d := s.seedDivision()
+	entry := s.saveHistory(d, "Januari", 2024)
+	path := fmt.Sprintf("/history/%d", entry.ID)
+	january := func(extra gin.H) gin.H {
+		body := gin.H{"periodMonth": "Januari", "periodYear": 2024, "divisionId": d.ID}
+		for k, v := range extra { body[k] = v }
+		return body
+	}
+	save := func(month string) gin.H {
+		return gin.H{"divisionId": d.ID, "employeeId": d.Employee.ID, "periodMonth": month, "periodYear": 2024, "realisasiInputs": d.Inputs}
+	}
+
+	// Each step runs against the state the previous ones left
+	steps := []struct {
+		name   string
+		method string
+		path   string
+		body   any
+		status int
+	}{
+		{name: "close", method: http.MethodPost, path: "/periods/close", body: january(nil), status: http.StatusOK},
+		{name: "close twice", method: http.MethodPost, path: "/periods/close", body: january(nil), status: http.StatusConflict},
+		{name: "save in closed period", method: http.MethodPost, path: "/history", body: save("Januari"), status: http.StatusLocked},
+		{name: "amend in closed period", method: http.MethodPut, path: path, body: gin.H{"realisasiInputs": d.Inputs, "reason": "koreksi"}, status: http.StatusLocked},
+		{name: "delete in closed period", method: http.MethodDelete, path: path, status: http.StatusLocked},
+		{name: "other periods stay open", method: http.MethodPost, path: "/history", body: save("Februari"), status: http.StatusCreated},
+		{name: "reopen without reason", method: http.MethodPost, path: "/periods/reopen", body: january(nil), status: http.StatusBadRequest},
+		{name: "reopen", method: http.MethodPost, path: "/periods/reopen", body: january(gin.H{"reason": "koreksi omset"}), status: http.StatusOK},
+		{name: "reopen twice", method: http.MethodPost, path: "/periods/reopen", body: january(gin.H{"reason": "koreksi omset"}), status: http.StatusConflict},
+		{name: "close for every division", method: http.MethodPost, path: "/periods/close", body: gin.H{"periodMonth": "Maret", "periodYear": 2024}, status: http.StatusOK},
+		{name: "save in period closed for every division", method: http.MethodPost, path: "/history", body: save("Maret"), status: http.StatusLocked},
+		{name: "delete after reopening", method: http.MethodDelete, path: path, status: http.StatusNoContent},
+	}
+	for _, st := range steps {
+		w := s.do(st.method, st.path, st.body)
+		if w.Code != st.status { t.Fatalf("%s: status = %d, want %d: %s", st.name, w.Code, st.status, w.Body.String()) }
+	}
+
+	var closure PeriodClosure
+	if err := db.Where("period_id = ? AND division_id = ?", *entry.PeriodID, d.ID).First(&closure).Error; err != nil { t.Fatal(err) }
+	if closure.Status != PeriodOpen || closure.StatusReason != "koreksi omset" { t.Errorf("closure is %s with reason %q, want open with the reopen reason", closure.Status, closure.StatusReason) }
+	if closure.ConfigVersionID == nil { t.Error("closing did not pin the configuration") }
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// KpiRecalculation compares one KPI line of a stored result with its recalculation; either side
+// is nil when the KPI only exists in one of the two configurations
+type KpiRecalculation struct {
+	KpiID        uint             `json:"kpiId"`
+	Name         string           `json:"name"`
+	Input        *string          `json:"input"`   // realisasi as typed; nil for derived KPIs
+	Derived      bool             `json:"derived"` // calculated by formula or ROAS, not entered
+	Stored       *KpiResultDetail `json:"stored"`
+	Recalculated *KpiResultDetail `json:"recalculated"`
+	PoinDelta    float64          `json:"poinDelta"`
+}
+
+// RecalculationResponse re-runs a history entry's inputs against a configuration
+type RecalculationResponse struct {
+	HistoryID        uint               `json:"historyId"`
+	Config           string             `json:"config"`       // original | current
+	InputsSource     string             `json:"inputsSource"` // stored, or results for entries saved before inputs were kept
+	RealisasiInputs  map[uint]string    `json:"realisasiInputs"`
+	Stored           CalculationResult  `json:"stored"`
+	Recalculated     CalculationResult  `json:"recalculated"`
+	TotalPointsDelta float64            `json:"totalPointsDelta"`
+	BonusDelta       float64            `json:"bonusDelta"`
+	MultiplierDelta  float64            `json:"multiplierDelta"`
+	Kpis             []KpiRecalculation `json:"kpis"`
+}
+
+// isDerivedKpi reports whether a KPI's realisasi is calculated rather than entered
+func isDerivedKpi(k KpiConfig) bool { return k.Formula != nil || (k.SpecialCalc != nil && *k.SpecialCalc == "ROAS") }
+
+// historyInputs returns the realisasi inputs an entry was saved with. Entries saved before
+// inputs were kept fall back to the realisasi of their entered KPIs.
+func historyInputs(entry HistoryEntry, stored CalculationResult, cfg DivisionConfig) (map[uint]string, string) {
+	var inputs map[uint]string
+	if entry.InputsJSON != "" && json.Unmarshal([]byte(entry.InputsJSON), &inputs) == nil && len(inputs) > 0 { return inputs, "stored" }
+	derived := map[uint]bool{}
+	for _, k := range cfg.KpiConfigs { derived[k.ID] = isDerivedKpi(k) }
+	inputs = map[uint]string{}
+	for _, d := range stored.Details {
+		if !derived[d.ID] { inputs[d.ID] = strconv.FormatFloat(d.Realisasi, 'f', -1, 64) }
+	}
+	return inputs, "results"
+}
+
+// recalculateHistory runs the entry's inputs through cfg and lines the result up against
+// what was stored; original is the configuration the entry was saved with
+func recalculateHistory(entry HistoryEntry, original, cfg DivisionConfig, grade string) RecalculationResponse {
+	var stored CalculationResult
+	if entry.ResultsJSON != "" { _ = json.Unmarshal([]byte(entry.ResultsJSON), &stored) }
+	inputs, source := historyInputs(entry, stored, original)
+	recalculated := cfg.Calculate(grade, inputs)
+
+	resp := RecalculationResponse{
+		HistoryID: entry.ID, InputsSource: source, RealisasiInputs: inputs, Stored: stored, Recalculated: recalculated,
+		TotalPointsDelta: recalculated.GrandTotalPoin - stored.GrandTotalPoin,
+		BonusDelta:       recalculated.FinalBonus - stored.FinalBonus,
+		MultiplierDelta:  recalculated.ActiveMultiplier - stored.ActiveMultiplier,
+		Kpis:             []KpiRecalculation{},
+	}
+	kpis := map[uint]KpiConfig{}
+	for _, k := range original.KpiConfigs { kpis[k.ID] = k }
+	for _, k := range cfg.KpiConfigs { kpis[k.ID] = k }
+	lines := map[uint]int{}
+	line := func(id uint) *KpiRecalculation {
+		if i, ok := lines[id]; ok { return &resp.Kpis[i] }
+		k := kpis[id]
+		kr := KpiRecalculation{KpiID: id, Name: k.Name, Derived: isDerivedKpi(k)}
+		if v, ok := inputs[id]; ok && !kr.Derived { kr.Input = &v }
+		lines[id] = len(resp.Kpis)
+		resp.Kpis = append(resp.Kpis, kr)
+		return &resp.Kpis[len(resp.Kpis)-1]
+	}
+	for i := range stored.Details { line(stored.Details[i].ID).Stored = &stored.Details[i] }
+	for i := range recalculated.Details { line(recalculated.Details[i].ID).Recalculated = &recalculated.Details[i] }
+	for i := range resp.Kpis {
+		kr := &resp.Kpis[i]
+		if kr.Recalculated != nil { kr.PoinDelta += kr.Recalculated.Poin }
+		if kr.Stored != nil { kr.PoinDelta -= kr.Stored.Poin }
+	}
+	return resp
+}
+
+func registerRecalculateRoutes(r *gin.RouterGroup) {
+	// GET /history/:id/recalculate?config=original|current re-runs the stored realisasi inputs
+	// against the configuration the entry was saved with (default) or the division's current
+	// one and shows how points, bonus and every KPI line would differ. Nothing is saved.
+	r.GET("/history/:id/recalculate", func(c *gin.Context) {
+		id, ok := parseID(c)
+		if !ok { return }
+		mode := c.DefaultQuery("config", "original")
+		if mode != "original" && mode != "current" { c.JSON(http.StatusBadRequest, gin.H{"error": "config must be original or current"}); return }
+		var entry HistoryEntry
+		if err := db.First(&entry, id).Error; err != nil { respondLookupError(c, "history entry", err); return }
+		if !currentUser(c).canReadHistory(entry) { c.JSON(http.StatusNotFound, gin.H{"error": "history entry not found"}); return }
+
+		original, err := historyConfig(entry)
+		if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()}); return }
+		cfg := original
+		if mode == "current" {
+			if cfg, err = loadDivisionConfig(db, entry.DivisionID); err != nil { respondLookupError(c, "division", err); return }
+		}
+		// The grade the entry was saved with, so a later promotion is not reported as a difference
+		grade, err := historyGrade(db, entry)
+		if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()}); return }
+		resp := recalculateHistory(entry, original, cfg, grade)
+		resp.Config = mode
+		c.JSON(http.StatusOK, resp)
+	})
+}
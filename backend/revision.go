@@ -0,0 +1,125 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+var errStaleRevision = errors.New("history entry was amended concurrently; reload and retry")
+
+// HistoryAmendRequest corrects a history entry with new realisasi inputs; the recalculated
+// result is saved as a new revision and the amended one is kept in the chain
+type HistoryAmendRequest struct {
+	RealisasiInputs map[uint]string `json:"realisasiInputs"`
+	Date            string          `json:"date"`
+	Reason          string          `json:"reason"`
+	TotalPoints     *float64        `json:"totalPoints"`
+	Bonus           *float64        `json:"bonus"`
+}
+
+// migrateHistoryRevisions makes every entry saved before revisions existed its own current
+// first revision
+func migrateHistoryRevisions() {
+	res := db.Model(&HistoryEntry{}).Where("revision IS NULL OR revision = 0").Updates(map[string]any{"revision": 1, "is_current": true})
+	if res.Error != nil { log.Printf("Failed to backfill history revisions: %v", res.Error); return }
+	if res.RowsAffected > 0 { log.Printf("Marked %d legacy history entries as first revisions", res.RowsAffected) }
+}
+
+// setCurrentRevision flips the current marker of one revision, auditing the change. It fails
+// with errStaleRevision when the marker already has that value.
+func setCurrentRevision(tx *gorm.DB, id uint, current bool) error {
+	var entry HistoryEntry
+	if err := tx.First(&entry, id).Error; err != nil { return err }
+	before := entry
+	res := tx.Model(&HistoryEntry{}).Where("id = ? AND is_current = ?", id, !current).Update("is_current", current)
+	if res.Error != nil { return res.Error }
+	if res.RowsAffected == 0 { return errStaleRevision }
+	entry.IsCurrent = current
+	return recordAudit(tx, AuditUpdate, "history", &before, &entry)
+}
+
+// revisionChain returns every revision of the entry's chain, oldest first
+func revisionChain(tx *gorm.DB, entry HistoryEntry) ([]HistoryEntry, error) {
+	chain := []HistoryEntry{entry}
+	for chain[0].PreviousID != nil {
+		var prev HistoryEntry
+		if err := tx.First(&prev, *chain[0].PreviousID).Error; err != nil { return nil, err }
+		chain = append([]HistoryEntry{prev}, chain...)
+	}
+	for {
+		var next HistoryEntry
+		err := tx.Where("previous_id = ?", chain[len(chain)-1].ID).First(&next).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) { return chain, nil }
+		if err != nil { return nil, err }
+		chain = append(chain, next)
+	}
+}
+
+func registerRevisionRoutes(r *gin.RouterGroup) {
+	// PUT /history/:id amends the current revision of an entry: the inputs are recalculated
+	// against the division's configuration and saved as a new draft revision linked to the
+	// amended one, which stays in the chain but is no longer current. A reason is required.
+	// Approved and paid entries are locked like they are for DELETE.
+	r.PUT("/history/:id", requireRole(RoleAdmin, RoleManager), func(c *gin.Context) {
+		id, ok := parseID(c)
+		if !ok { return }
+		var req HistoryAmendRequest
+		if err := c.ShouldBindJSON(&req); err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()}); return }
+		req.Reason = strings.TrimSpace(req.Reason)
+		if req.Reason == "" { c.JSON(http.StatusBadRequest, gin.H{"error": "reason is required to amend a history entry"}); return }
+		if len(req.RealisasiInputs) == 0 { c.JSON(http.StatusBadRequest, gin.H{"error": "realisasiInputs is required"}); return }
+
+		var entry HistoryEntry
+		if err := db.First(&entry, id).Error; err != nil { respondLookupError(c, "history entry", err); return }
+		if !currentUser(c).canManageDivision(entry.DivisionID) { c.JSON(http.StatusForbidden, gin.H{"error": "not allowed to amend history for this division"}); return }
+		if isLockedStatus(entry.Status) { c.JSON(http.StatusLocked, gin.H{"error": "history entry is " + entry.Status + " and can no longer be amended"}); return }
+		if !entry.IsCurrent { c.JSON(http.StatusConflict, gin.H{"error": "only the current revision can be amended"}); return }
+		period, err := newPeriod(entry.PeriodMonth, entry.PeriodYear)
+		if err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()}); return }
+		if err := checkHistoryOpen(db, entry); err != nil { respondPeriodError(c, err); return }
+
+		cfg, err := loadDivisionConfig(db, entry.DivisionID)
+		if err != nil { respondLookupError(c, "division", err); return }
+		// An amendment corrects the inputs of its period, so it keeps the grade the entry was saved
+		// with; a promotion since, or a deleted employee, does not change it
+		grade, err := historyGrade(db, entry)
+		if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()}); return }
+		results := cfg.Calculate(grade, req.RealisasiInputs)
+		if len(results.InputErrors) > 0 { c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "invalid realisasi inputs", "inputErrors": results.InputErrors}); return }
+		if !checkSubmittedTotals(c, req.TotalPoints, req.Bonus, results) { return }
+
+		date := entry.Date
+		if t, err := time.Parse(time.RFC3339, req.Date); err == nil { date = t }
+		amended, err := newHistoryEntry(entry.DivisionID, entry.EmployeeID, entry.EmployeeName, grade, date, period, req.RealisasiInputs, results)
+		if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()}); return }
+		amended.Revision, amended.PreviousID, amended.AmendReason = entry.Revision+1, &entry.ID, req.Reason
+
+		err = db.WithContext(c).Transaction(func(tx *gorm.DB) error {
+			if err := setCurrentRevision(tx, entry.ID, false); err != nil { return err }
+			return createHistory(tx, cfg, &amended)
+		})
+		if errors.Is(err, errStaleRevision) || errors.Is(err, errDuplicateHistory) { c.JSON(http.StatusConflict, gin.H{"error": err.Error()}); return }
+		if err != nil { respondPeriodError(c, err); return }
+		c.JSON(http.StatusCreated, toHistoryResponse(amended))
+	})
+
+	// GET /history/:id/revisions lists the revision chain of an entry, oldest first
+	r.GET("/history/:id/revisions", func(c *gin.Context) {
+		id, ok := parseID(c)
+		if !ok { return }
+		var entry HistoryEntry
+		if err := db.First(&entry, id).Error; err != nil { respondLookupError(c, "history entry", err); return }
+		if !currentUser(c).canReadHistory(entry) { c.JSON(http.StatusNotFound, gin.H{"error": "history entry not found"}); return }
+		chain, err := revisionChain(db, entry)
+		if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()}); return }
+		items := make([]HistoryResponse, 0, len(chain))
+		for _, it := range chain { items = append(items, toHistoryResponse(it)) }
+		c.JSON(http.StatusOK, items)
+	})
+}
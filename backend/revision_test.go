@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestAmendAndDeleteRestoresRevision(t *testing.T) {
+	s := newTestServer(t)
+	d := s.seedDivision()
+	original := s.saveHistory(d, "Januari", 2024)
+	// A promotion after saving does not change the grade amendments are calculated with
+	if err := db.Model(&Employee{}).Where("id = ?", d.Employee.ID).Update("grade", "Senior").Error; err != nil { t.Fatal(err) }
+
+	inputs := map[uint]string{d.Omset.ID: "150000000", d.Biaya.ID: "8000000"}
+	var amended HistoryResponse
+	s.decode(s.do(http.MethodPut, fmt.Sprintf("/history/%d", original.ID), gin.H{"realisasiInputs": inputs, "reason": "omset final"}), http.StatusCreated, &amended)
+	if amended.Revision != 2 || amended.PreviousID == nil || *amended.PreviousID != original.ID { t.Fatalf("amendment is revision %d of %v, want 2 of %d", amended.Revision, amended.PreviousID, original.ID) }
+	if amended.Bonus == original.Bonus { t.Errorf("amendment kept bonus %v; it was not recalculated", amended.Bonus) }
+	if amended.Grade != original.Grade { t.Errorf("amendment grade = %q, want the stored %q", amended.Grade, original.Grade) }
+
+	var chain []HistoryResponse
+	s.decode(s.do(http.MethodGet, fmt.Sprintf("/history/%d/revisions", amended.ID), nil), http.StatusOK, &chain)
+	if len(chain) != 2 || chain[0].ID != original.ID || chain[1].ID != amended.ID { t.Fatalf("revision chain = %+v, want %d then %d", chain, original.ID, amended.ID) }
+
+	steps := []struct {
+		name   string
+		method string
+		path   string
+		body   any
+		status int
+	}{
+		{name: "amend a superseded revision", method: http.MethodPut, path: fmt.Sprintf("/history/%d", original.ID), body: gin.H{"realisasiInputs": inputs, "reason": "lagi"}, status: http.StatusConflict},
+		{name: "delete a superseded revision", method: http.MethodDelete, path: fmt.Sprintf("/history/%d", original.ID), status: http.StatusConflict},
+		{name: "save the period again", method: http.MethodPost, path: "/history", body: gin.H{"divisionId": d.ID, "employeeId": d.Employee.ID, "periodMonth": "Januari", "periodYear": 2024, "realisasiInputs": inputs}, status: http.StatusConflict},
+		{name: "delete the amendment", method: http.MethodDelete, path: fmt.Sprintf("/history/%d", amended.ID), status: http.StatusNoContent},
+	}
+	for _, st := range steps {
+		w := s.do(st.method, st.path, st.body)
+		if w.Code != st.status { t.Fatalf("%s: status = %d, want %d: %s", st.name, w.Code, st.status, w.Body.String()) }
+	}
+
+	var restored HistoryEntry
+	if err := db.First(&restored, original.ID).Error; err != nil { t.Fatal(err) }
+	if !restored.IsCurrent { t.Error("deleting the amendment did not make the original current again") }
+	var results int64
+	db.Model(&HistoryKpiResult{}).Where("history_id = ?", amended.ID).Count(&results)
+	if results != 0 { t.Errorf("%d KPI results of the deleted amendment left", results) }
+	// The restored revision is the one that can be amended again
+	s.decode(s.do(http.MethodPut, fmt.Sprintf("/history/%d", original.ID), gin.H{"realisasiInputs": inputs, "reason": "omset final"}), http.StatusCreated, nil)
+}
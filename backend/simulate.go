@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// SimulationOverrides changes configuration rows for one simulation only. Each item is a
+// partial row with its id, merged over the live row as a PATCH would, e.g.
+// {"kpiConfigs": [{"id": 2, "target": 200000000}]}.
+type SimulationOverrides struct {
+	KpiConfigs    []json.RawMessage `json:"kpiConfigs"`
+	BonusSchemes  []json.RawMessage `json:"bonusSchemes"`
+	KpiIndicators []json.RawMessage `json:"kpiIndicators"`
+}
+
+// SimulateRequest simulates one employee, from a stored history entry (historyId) or from
+// realisasiInputs with employeeId, or every current entry of a period (periodMonth and
+// periodYear)
+type SimulateRequest struct {
+	DivisionID      uint                `json:"divisionId"`
+	HistoryID       *uint               `json:"historyId"`
+	EmployeeID      *uint               `json:"employeeId"` // selects the grade for realisasiInputs
+	RealisasiInputs map[uint]string     `json:"realisasiInputs"`
+	PeriodMonth     string              `json:"periodMonth"`
+	PeriodYear      int                 `json:"periodYear"`
+	Overrides       SimulationOverrides `json:"overrides"`
+}
+
+// SimulationResult lines up the current configuration's result with the overridden one
+type SimulationResult struct {
+	HistoryID        *uint              `json:"historyId,omitempty"`
+	EmployeeID       uint               `json:"employeeId"`
+	EmployeeName     string             `json:"employeeName"`
+	RealisasiInputs  map[uint]string    `json:"realisasiInputs"`
+	Stored           *CalculationResult `json:"stored,omitempty"` // what the history entry was saved with
+	Old              CalculationResult  `json:"old"`
+	New              CalculationResult  `json:"new"`
+	TotalPointsDelta float64            `json:"totalPointsDelta"`
+	BonusDelta       float64            `json:"bonusDelta"`
+}
+
+// PayrollSimulationRow is one entry of a period simulation
+type PayrollSimulationRow struct {
+	HistoryID    uint    `json:"historyId"`
+	EmployeeID   uint    `json:"employeeId"`
+	EmployeeName string  `json:"employeeName"`
+	StoredBonus  float64 `json:"storedBonus"`
+	OldBonus     float64 `json:"oldBonus"`
+	NewBonus     float64 `json:"newBonus"`
+	BonusDelta   float64 `json:"bonusDelta"`
+	OldPoints    float64 `json:"oldPoints"`
+	NewPoints    float64 `json:"newPoints"`
+}
+
+// PayrollSimulation totals the bonus of a period's current history before and after the overrides
+type PayrollSimulation struct {
+	DivisionID    uint                   `json:"divisionId"`
+	PeriodMonth   string                 `json:"periodMonth"`
+	PeriodYear    int                    `json:"periodYear"`
+	Entries       int                    `json:"entries"`
+	Changed       int                    `json:"changed"` // entries whose bonus differs
+	StoredPayroll float64                `json:"storedPayroll"`
+	OldPayroll    float64                `json:"oldPayroll"`
+	NewPayroll    float64                `json:"newPayroll"`
+	PayrollDelta  float64                `json:"payrollDelta"`
+	Rows          []PayrollSimulationRow `json:"rows"`
+}
+
+// overrideRows returns a copy of rows with each patch merged over the row of the same id and
+// validated by prepare. The live rows are left untouched; key reports a row's id and division.
+func overrideRows[T any](rows []T, patches []json.RawMessage, entity string, key func(T) (uint, uint), prepare func(uint, *T) error) ([]T, error) {
+	out := append([]T(nil), rows...)
+	for _, raw := range patches {
+		var ref struct{ ID uint `json:"id"` }
+		if err := json.Unmarshal(raw, &ref); err != nil { return nil, fmt.Errorf("%s override: %w", entity, err) }
+		i := -1
+		for j := range out {
+			if id, _ := key(out[j]); id == ref.ID { i = j; break }
+		}
+		if i < 0 { return nil, fmt.Errorf("%s %d is not part of this division", entity, ref.ID) }
+		// Round-trip through JSON so the patch cannot write through pointers shared with the live row
+		b, err := json.Marshal(out[i])
+		if err != nil { return nil, err }
+		var row T
+		if err := json.Unmarshal(b, &row); err != nil { return nil, err }
+		dec := json.NewDecoder(bytes.NewReader(raw))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&row); err != nil { return nil, fmt.Errorf("%s %d override: %w", entity, ref.ID, err) }
+		id, division := key(row)
+		if _, want := key(out[i]); id != ref.ID || division != want { return nil, fmt.Errorf("%s %d override cannot change id or divisionId", entity, ref.ID) }
+		if err := prepare(id, &row); err != nil { return nil, fmt.Errorf("%s %d override: %w", entity, ref.ID, err) }
+		out[i] = row
+	}
+	return out, nil
+}
+
+// apply returns cfg with the overrides merged in
+func (o SimulationOverrides) apply(cfg DivisionConfig) (DivisionConfig, error) {
+	var err error
+	kpiFields := func(_ uint, k *KpiConfig) error { return prepareKpiConfigFields(k) }
+	if cfg.KpiConfigs, err = overrideRows(cfg.KpiConfigs, o.KpiConfigs, "kpi config", func(k KpiConfig) (uint, uint) { return k.ID, k.DivisionID }, kpiFields); err != nil { return cfg, err }
+	if cfg.BonusSchemes, err = overrideRows(cfg.BonusSchemes, o.BonusSchemes, "bonus scheme", func(s BonusScheme) (uint, uint) { return s.ID, s.DivisionID }, prepareBonusScheme); err != nil { return cfg, err }
+	if cfg.KpiIndicators, err = overrideRows(cfg.KpiIndicators, o.KpiIndicators, "kpi indicator", func(ind KpiIndicator) (uint, uint) { return ind.ID, ind.DivisionID }, prepareKpiIndicator); err != nil { return cfg, err }
+	// Rows are checked on their own above; codes and formulas only as the whole overridden set,
+	// so swapping two codes or renaming a code with the formula using it is accepted
+	codes := map[string]string{}
+	for _, k := range cfg.KpiConfigs {
+		if k.Code == "" { continue }
+		if other, dup := codes[k.Code]; dup { return cfg, fmt.Errorf("code %q is used by both %q and %q", k.Code, other, k.Name) }
+		codes[k.Code] = k.Name
+	}
+	return cfg, ValidateKpiFormulas(cfg.KpiConfigs)
+}
+
+// simulate runs the inputs through the current and the overridden configuration
+func simulate(base, cfg DivisionConfig, grade string, inputs map[uint]string) SimulationResult {
+	old, next := base.Calculate(grade, inputs), cfg.Calculate(grade, inputs)
+	return SimulationResult{
+		RealisasiInputs: inputs, Old: old, New: next,
+		TotalPointsDelta: next.GrandTotalPoin - old.GrandTotalPoin,
+		BonusDelta:       next.FinalBonus - old.FinalBonus,
+	}
+}
+
+// simulatePayroll simulates every current history entry of the division in p
+func simulatePayroll(base, cfg DivisionConfig, p Period) (PayrollSimulation, error) {
+	sim := PayrollSimulation{DivisionID: base.Division.ID, PeriodMonth: p.MonthName(), PeriodYear: p.Year, Rows: []PayrollSimulationRow{}}
+	var entries []HistoryEntry
+	err := db.Where("division_id = ? AND is_current = ? AND period_id IN (?)", base.Division.ID, true,
+		db.Model(&Period{}).Select("id").Where("year = ? AND month = ?", p.Year, p.Month)).Order("employee_name, id").Find(&entries).Error
+	if err != nil { return sim, err }
+	var list []Employee
+	if err := db.Where("division_id = ?", base.Division.ID).Find(&list).Error; err != nil { return sim, err }
+	grades := map[uint]string{}
+	for _, e := range list { grades[e.ID] = e.Grade }
+
+	for _, e := range entries {
+		var stored CalculationResult
+		if e.ResultsJSON != "" { _ = json.Unmarshal([]byte(e.ResultsJSON), &stored) }
+		inputs, _ := historyInputs(e, stored, base)
+		grade := e.Grade
+		if grade == "" { grade = grades[e.EmployeeID] }
+		res := simulate(base, cfg, grade, inputs)
+		sim.Rows = append(sim.Rows, PayrollSimulationRow{
+			HistoryID: e.ID, EmployeeID: e.EmployeeID, EmployeeName: e.EmployeeName, StoredBonus: e.Bonus,
+			OldBonus: res.Old.FinalBonus, NewBonus: res.New.FinalBonus, BonusDelta: res.BonusDelta,
+			OldPoints: res.Old.GrandTotalPoin, NewPoints: res.New.GrandTotalPoin,
+		})
+		sim.StoredPayroll += e.Bonus
+		sim.OldPayroll += res.Old.FinalBonus
+		sim.NewPayroll += res.New.FinalBonus
+		if res.BonusDelta != 0 { sim.Changed++ }
+	}
+	sim.Entries = len(entries)
+	sim.PayrollDelta = sim.NewPayroll - sim.OldPayroll
+	return sim, nil
+}
+
+func registerSimulateRoutes(r *gin.RouterGroup) {
+	// POST /simulate answers "what if" questions without touching the live configuration. The
+	// overrides are merged over the division's current KPI configs, bonus schemes and KPI
+	// indicators, and the result with and without them is returned side by side, for a stored
+	// entry (historyId), for typed realisasiInputs of an employee, or, with periodMonth and
+	// periodYear, for every current entry of the period with the total payroll impact.
+	r.POST("/simulate", requireRole(RoleAdmin, RoleManager), func(c *gin.Context) {
+		var req SimulateRequest
+		if err := c.ShouldBindJSON(&req); err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()}); return }
+		modes := 0
+		if req.HistoryID != nil { modes++ }
+		if len(req.RealisasiInputs) > 0 { modes++ }
+		if req.PeriodMonth != "" || req.PeriodYear != 0 { modes++ }
+		if modes != 1 { c.JSON(http.StatusBadRequest, gin.H{"error": "provide exactly one of historyId, realisasiInputs or periodMonth/periodYear"}); return }
+
+		var entry HistoryEntry
+		if req.HistoryID != nil {
+			if err := db.First(&entry, *req.HistoryID).Error; err != nil { respondLookupError(c, "history entry", err); return }
+			if req.DivisionID == 0 { req.DivisionID = entry.DivisionID }
+			if entry.DivisionID != req.DivisionID { c.JSON(http.StatusBadRequest, gin.H{"error": "history entry belongs to another division"}); return }
+		}
+		if !currentUser(c).canManageDivision(req.DivisionID) { c.JSON(http.StatusForbidden, gin.H{"error": "not allowed to simulate for this division"}); return }
+		base, err := loadDivisionConfig(db, req.DivisionID)
+		if err != nil { respondLookupError(c, "division", err); return }
+		cfg, err := req.Overrides.apply(base)
+		if err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()}); return }
+
+		if req.HistoryID == nil && len(req.RealisasiInputs) == 0 {
+			p, err := newPeriod(req.PeriodMonth, req.PeriodYear)
+			if err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()}); return }
+			sim, err := simulatePayroll(base, cfg, p)
+			if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()}); return }
+			c.JSON(http.StatusOK, sim)
+			return
+		}
+
+		employeeID := entry.EmployeeID
+		if req.HistoryID == nil {
+			if req.EmployeeID == nil { c.JSON(http.StatusBadRequest, gin.H{"error": "employeeId is required with realisasiInputs"}); return }
+			employeeID = *req.EmployeeID
+		}
+		// A history entry may outlive its employee; it keeps the grade it was saved with
+		var employee Employee
+		err = db.First(&employee, employeeID).Error
+		if err != nil && (req.HistoryID == nil || !errors.Is(err, gorm.ErrRecordNotFound)) { respondLookupError(c, "employee", err); return }
+		if req.HistoryID == nil && employee.DivisionID != req.DivisionID { c.JSON(http.StatusBadRequest, gin.H{"error": "employee belongs to another division"}); return }
+
+		inputs, grade := req.RealisasiInputs, employee.Grade
+		var stored *CalculationResult
+		if req.HistoryID != nil {
+			stored = &CalculationResult{}
+			if entry.ResultsJSON != "" { _ = json.Unmarshal([]byte(entry.ResultsJSON), stored) }
+			inputs, _ = historyInputs(entry, *stored, base)
+			if entry.Grade != "" { grade = entry.Grade }
+		}
+		res := simulate(base, cfg, grade, inputs)
+		if len(res.Old.InputErrors) > 0 { c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "invalid realisasi inputs", "inputErrors": res.Old.InputErrors}); return }
+		if len(res.New.InputErrors) > 0 { c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "overrides cannot be calculated", "inputErrors": res.New.InputErrors}); return }
+		res.HistoryID, res.EmployeeID, res.Stored = req.HistoryID, employeeID, stored
+		res.EmployeeName = employee.Name
+		if req.HistoryID != nil { res.EmployeeName = entry.EmployeeName }
+		c.JSON(http.StatusOK, res)
+	})
+}
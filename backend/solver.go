@@ -0,0 +1,224 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Upper bound of the realisasi search, in input units (rupiah, or hundredths for other KPIs)
+const maxSolveUnits = int64(1) << 50
+
+// SolveRequest asks what an employee still needs to reach a bonus scheme tier or KPI indicator,
+// from a stored history entry (historyId) or partial realisasiInputs of employeeId. Without
+// schemeId or indicatorId the next scheme tier above the current one is the target.
+type SolveRequest struct {
+	DivisionID      uint            `json:"divisionId"`
+	HistoryID       *uint           `json:"historyId"`
+	EmployeeID      *uint           `json:"employeeId"`
+	RealisasiInputs map[uint]string `json:"realisasiInputs"`
+	SchemeID        *uint           `json:"schemeId"`
+	IndicatorID     *uint           `json:"indicatorId"`
+}
+
+// SolveTarget is the tier or indicator to reach and the measure it is reached on
+type SolveTarget struct {
+	Kind      string  `json:"kind"` // scheme | indicator
+	ID        uint    `json:"id"`
+	Name      string  `json:"name"`
+	Threshold float64 `json:"threshold"`
+	Measure   string  `json:"measure"` // points, or omset for OMSET_BASED schemes
+}
+
+// KpiRequirement is the realisasi one entered KPI needs to reach the target when every other
+// input stays as it is
+type KpiRequirement struct {
+	KpiID     uint     `json:"kpiId"`
+	Name      string   `json:"name"`
+	Type      string   `json:"type"`
+	Current   float64  `json:"current"`
+	Required  *float64 `json:"required"` // nil when this KPI alone cannot reach the target
+	Change    float64  `json:"change"`   // Required - Current; negative for lower_is_better KPIs
+	Reachable bool     `json:"reachable"`
+	Points    float64  `json:"points"` // result at the required realisasi
+	Omset     float64  `json:"omset"`
+	Bonus     float64  `json:"bonus"`
+}
+
+// SolveResponse lists, per entered KPI, the minimum change that reaches the target
+type SolveResponse struct {
+	EmployeeID      uint              `json:"employeeId"`
+	EmployeeName    string            `json:"employeeName"`
+	RealisasiInputs map[uint]string   `json:"realisasiInputs"`
+	Target          SolveTarget       `json:"target"`
+	Current         float64           `json:"current"` // current value of the target's measure
+	Reached         bool              `json:"reached"`
+	Result          CalculationResult `json:"result"`
+	Kpis            []KpiRequirement  `json:"kpis"`
+}
+
+// measure returns the value the target is compared against
+func (t SolveTarget) measure(res CalculationResult) float64 {
+	if t.Measure == "omset" { return res.TotalOmsetRealisasi }
+	return res.GrandTotalPoin
+}
+
+// solveTarget resolves the requested target; without one it picks the lowest scheme tier above
+// the current measure, or the top tier when every tier is reached
+func solveTarget(cfg DivisionConfig, req SolveRequest, res CalculationResult) (SolveTarget, error) {
+	if req.SchemeID != nil && req.IndicatorID != nil { return SolveTarget{}, errors.New("provide schemeId or indicatorId, not both") }
+	if req.IndicatorID != nil {
+		for _, ind := range cfg.KpiIndicators {
+			if ind.ID == *req.IndicatorID { return SolveTarget{Kind: "indicator", ID: ind.ID, Name: ind.Name, Threshold: ind.Threshold, Measure: "points"}, nil }
+		}
+		return SolveTarget{}, errors.New("kpi indicator is not part of this division")
+	}
+	if cfg.Division.BonusCalculationMethod == "NON_SALES" { return SolveTarget{}, errors.New("division does not pay bonus by scheme tiers; use indicatorId") }
+	t := SolveTarget{Kind: "scheme", Measure: "points"}
+	if cfg.Division.BonusCalculationMethod != "POINTS_BASED" { t.Measure = "omset" }
+	m := t.measure(res)
+	var pick *BonusScheme
+	for i, s := range cfg.BonusSchemes {
+		if req.SchemeID != nil {
+			if s.ID == *req.SchemeID { pick = &cfg.BonusSchemes[i] }
+			continue
+		}
+		switch {
+		case pick == nil:
+			pick = &cfg.BonusSchemes[i]
+		case s.Threshold > m && (pick.Threshold <= m || s.Threshold < pick.Threshold): // lowest tier above
+			pick = &cfg.BonusSchemes[i]
+		case s.Threshold <= m && pick.Threshold <= m && s.Threshold > pick.Threshold: // top tier while none is above
+			pick = &cfg.BonusSchemes[i]
+		}
+	}
+	if pick == nil && req.SchemeID != nil { return t, errors.New("bonus scheme is not part of this division") }
+	if pick == nil { return t, errors.New("division has no bonus schemes") }
+	t.ID, t.Name, t.Threshold = pick.ID, pick.Name, pick.Threshold
+	return t, nil
+}
+
+// solveKpi searches the realisasi of one entered KPI that reaches the target with the other
+// inputs fixed. Every candidate runs through Calculate, so capping, derived KPIs and the
+// MinTarget of ROAS apply exactly as when the entry is saved. Values move in whole rupiah for
+// currency KPIs and hundredths otherwise; higher_is_better KPIs search upward from the current
+// value and the others downward, keeping realisasi above 0 since 0 earns no points.
+func solveKpi(cfg DivisionConfig, grade string, inputs map[uint]string, k KpiConfig, current float64, target SolveTarget) KpiRequirement {
+	req := KpiRequirement{KpiID: k.ID, Name: k.Name, Type: k.Type, Current: current}
+	unit, decimals := 0.01, 2
+	if k.IsCurrency { unit, decimals = 1, 0 }
+	trial := map[uint]string{}
+	for id, v := range inputs { trial[id] = v }
+	results := map[int64]CalculationResult{}
+	reached := func(units int64) bool {
+		if _, ok := results[units]; !ok {
+			trial[k.ID] = strconv.FormatFloat(float64(units)*unit, 'f', decimals, 64)
+			results[units] = cfg.Calculate(grade, trial)
+		}
+		return target.measure(results[units]) >= target.Threshold
+	}
+
+	var found int64
+	if k.Type == "higher_is_better" {
+		lo := int64(math.Max(math.Ceil(current/unit), 0))
+		if reached(lo) {
+			found = lo
+		} else {
+			hi := max(lo*2, int64(math.Ceil(k.Target/unit)), 1)
+			for !reached(hi) {
+				if hi >= maxSolveUnits { return req }
+				hi *= 2
+			}
+			for hi-lo > 1 {
+				mid := lo + (hi-lo)/2
+				if reached(mid) { hi = mid } else { lo = mid }
+			}
+			found = hi
+		}
+	} else {
+		lo := int64(1)
+		if !reached(lo) { return req }
+		hi := int64(math.Floor(current / unit))
+		if current <= 0 {
+			for hi = 2; reached(hi); hi *= 2 {
+				if hi >= maxSolveUnits { break }
+			}
+		}
+		if hi <= lo || reached(hi) {
+			found = max(hi, lo)
+		} else {
+			for hi-lo > 1 {
+				mid := lo + (hi-lo)/2
+				if reached(mid) { lo = mid } else { hi = mid }
+			}
+			found = lo
+		}
+	}
+
+	required := float64(found) * unit
+	res := results[found]
+	req.Required, req.Change, req.Reachable = &required, required-current, true
+	req.Points, req.Omset, req.Bonus = res.GrandTotalPoin, res.TotalOmsetRealisasi, res.FinalBonus
+	return req
+}
+
+func registerSolverRoutes(r *gin.RouterGroup) {
+	// POST /solve tells an employee what is still needed for a bonus scheme tier (schemeId) or KPI
+	// indicator (indicatorId), by default the next scheme tier. For every entered KPI it returns
+	// the realisasi that reaches the target on its own, using the division's current
+	// configuration and the same engine as /calculate. Nothing is saved.
+	r.POST("/solve", func(c *gin.Context) {
+		var req SolveRequest
+		if err := c.ShouldBindJSON(&req); err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()}); return }
+		if (req.HistoryID == nil) == (len(req.RealisasiInputs) == 0) { c.JSON(http.StatusBadRequest, gin.H{"error": "provide exactly one of historyId or realisasiInputs"}); return }
+
+		var entry HistoryEntry
+		employeeID := uint(0)
+		if req.HistoryID != nil {
+			if err := db.First(&entry, *req.HistoryID).Error; err != nil { respondLookupError(c, "history entry", err); return }
+			if req.DivisionID == 0 { req.DivisionID = entry.DivisionID }
+			if entry.DivisionID != req.DivisionID { c.JSON(http.StatusBadRequest, gin.H{"error": "history entry belongs to another division"}); return }
+			employeeID = entry.EmployeeID
+		} else {
+			if req.EmployeeID == nil { c.JSON(http.StatusBadRequest, gin.H{"error": "employeeId is required with realisasiInputs"}); return }
+			employeeID = *req.EmployeeID
+		}
+		// Employees may solve for themselves; managers for their division
+		if !currentUser(c).canReadHistory(HistoryEntry{DivisionID: req.DivisionID, EmployeeID: employeeID}) { c.JSON(http.StatusForbidden, gin.H{"error": "not allowed to solve for this employee"}); return }
+		var employee Employee
+		err := db.First(&employee, employeeID).Error
+		if err != nil && (req.HistoryID == nil || !errors.Is(err, gorm.ErrRecordNotFound)) { respondLookupError(c, "employee", err); return }
+		if req.HistoryID == nil && employee.DivisionID != req.DivisionID { c.JSON(http.StatusBadRequest, gin.H{"error": "employee belongs to another division"}); return }
+		cfg, err := loadDivisionConfig(db, req.DivisionID)
+		if err != nil { respondLookupError(c, "division", err); return }
+
+		inputs, grade := req.RealisasiInputs, employee.Grade
+		if req.HistoryID != nil {
+			var stored CalculationResult
+			if entry.ResultsJSON != "" { _ = json.Unmarshal([]byte(entry.ResultsJSON), &stored) }
+			inputs, _ = historyInputs(entry, stored, cfg)
+			if entry.Grade != "" { grade = entry.Grade }
+		}
+		res := cfg.Calculate(grade, inputs)
+		if len(res.InputErrors) > 0 { c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "invalid realisasi inputs", "inputErrors": res.InputErrors}); return }
+		target, err := solveTarget(cfg, req, res)
+		if err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()}); return }
+
+		resp := SolveResponse{EmployeeID: employeeID, EmployeeName: employee.Name, RealisasiInputs: inputs, Target: target, Current: target.measure(res), Result: res, Kpis: []KpiRequirement{}}
+		if req.HistoryID != nil { resp.EmployeeName = entry.EmployeeName }
+		resp.Reached = resp.Current >= target.Threshold
+		if !resp.Reached {
+			current := map[uint]float64{}
+			for _, d := range res.Details { current[d.ID] = d.Realisasi }
+			for _, k := range cfg.KpiConfigs {
+				if !isDerivedKpi(k) { resp.Kpis = append(resp.Kpis, solveKpi(cfg, grade, inputs, k, current[k.ID], target)) }
+			}
+		}
+		c.JSON(http.StatusOK, resp)
+	})
+}
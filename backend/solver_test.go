@@ -0,0 +1,71 @@
+package main
+
+import (
+	"math"
+	"strconv"
+	"testing"
+)
+
+func floatPtr(v float64) *float64 { return &v }
+
+func TestSolveKpi(t *testing.T) {
+	kpi := func(id uint, typ string, bobot, target float64, currency bool, role string) KpiConfig {
+		return KpiConfig{ID: id, DivisionID: 1, Name: "KPI " + strconv.Itoa(int(id)), Platform: "Shopee", Type: typ, Bobot: bobot, Target: target, IsCurrency: currency, PointCapping: "uncapped", Role: role}
+	}
+	capped := func(k KpiConfig) KpiConfig { k.PointCapping = "capped"; return k }
+	config := func(kpis ...KpiConfig) DivisionConfig {
+		return DivisionConfig{Division: Division{ID: 1, BonusCalculationMethod: "POINTS_BASED"}, KpiConfigs: kpis}
+	}
+	points := func(threshold float64) SolveTarget { return SolveTarget{Kind: "indicator", Threshold: threshold, Measure: "points"} }
+
+	// ROAS is derived as omset / biaya and scores 0 below its MinTarget of 4, 10 points per 1 above
+	roas := kpi(1, "higher_is_better", 50, 5, false, KpiRoleRatio)
+	roas.SpecialCalc, roas.MinTarget = strPtr("ROAS"), floatPtr(4)
+	roasCfg := config(roas, kpi(2, "higher_is_better", 0, 1, true, KpiRoleRevenue), kpi(3, "lower_is_better", 0, 1, true, KpiRoleCost))
+
+	tests := []struct {
+		name     string
+		cfg      DivisionConfig
+		inputs   map[uint]string
+		kpi      uint
+		target   SolveTarget
+		required *float64 // nil when unreachable
+	}{
+		{name: "higher from current", cfg: config(kpi(1, "higher_is_better", 100, 100, false, KpiRoleGeneric)), inputs: map[uint]string{1: "50"}, kpi: 1, target: points(80), required: floatPtr(80)},
+		{name: "higher from zero", cfg: config(kpi(1, "higher_is_better", 100, 100, false, KpiRoleGeneric)), inputs: map[uint]string{}, kpi: 1, target: points(80), required: floatPtr(80)},
+		{name: "higher already reached", cfg: config(kpi(1, "higher_is_better", 100, 100, false, KpiRoleGeneric)), inputs: map[uint]string{1: "90"}, kpi: 1, target: points(80), required: floatPtr(90)},
+		// 30/7 points per unit: 2.33 falls just short, so the next hundredth up
+		{name: "higher rounds up to a hundredth", cfg: config(kpi(1, "higher_is_better", 30, 7, false, KpiRoleGeneric)), inputs: map[uint]string{1: "1"}, kpi: 1, target: points(10), required: floatPtr(2.34)},
+		{name: "higher currency rounds up to a rupiah", cfg: config(kpi(1, "higher_is_better", 3, 1000000, true, KpiRoleRevenue)), inputs: map[uint]string{1: "100000"}, kpi: 1, target: points(1), required: floatPtr(333334)},
+		{name: "higher with other KPIs fixed", cfg: config(kpi(1, "higher_is_better", 50, 100, false, KpiRoleGeneric), kpi(2, "higher_is_better", 50, 100, false, KpiRoleGeneric)), inputs: map[uint]string{1: "40", 2: "60"}, kpi: 1, target: points(70), required: floatPtr(80)},
+		{name: "higher far above target", cfg: config(kpi(1, "higher_is_better", 100, 100, false, KpiRoleGeneric)), inputs: map[uint]string{1: "1"}, kpi: 1, target: points(1e9), required: floatPtr(1e9)},
+		{name: "higher beyond the search bound", cfg: config(kpi(1, "higher_is_better", 100, 100, false, KpiRoleGeneric)), inputs: map[uint]string{1: "1"}, kpi: 1, target: points(1e14)},
+		{name: "higher capped", cfg: config(capped(kpi(1, "higher_is_better", 100, 100, false, KpiRoleGeneric))), inputs: map[uint]string{1: "50"}, kpi: 1, target: points(120)},
+		// 1000 / biaya points
+		{name: "lower from current", cfg: config(kpi(1, "lower_is_better", 20, 50, true, KpiRoleCost)), inputs: map[uint]string{1: "200"}, kpi: 1, target: points(10), required: floatPtr(100)},
+		{name: "lower rounds down to a hundredth", cfg: config(kpi(1, "lower_is_better", 20, 50, false, KpiRoleCost)), inputs: map[uint]string{1: "150.5"}, kpi: 1, target: points(30), required: floatPtr(33.33)},
+		{name: "lower from zero", cfg: config(kpi(1, "lower_is_better", 20, 50, false, KpiRoleCost)), inputs: map[uint]string{}, kpi: 1, target: points(10), required: floatPtr(100)},
+		{name: "lower already reached", cfg: config(kpi(1, "lower_is_better", 20, 50, true, KpiRoleCost)), inputs: map[uint]string{1: "80"}, kpi: 1, target: points(10), required: floatPtr(80)},
+		{name: "lower capped", cfg: config(capped(kpi(1, "lower_is_better", 20, 50, true, KpiRoleCost))), inputs: map[uint]string{1: "200"}, kpi: 1, target: points(30)},
+		// ROAS 3 would give 30 points but scores 0 below 4, so omset has to reach ROAS 4
+		{name: "roas omset jumps to min target", cfg: roasCfg, inputs: map[uint]string{2: "100", 3: "100"}, kpi: 2, target: points(30), required: floatPtr(400)},
+		{name: "roas biaya down to min target", cfg: roasCfg, inputs: map[uint]string{2: "100", 3: "100"}, kpi: 3, target: points(30), required: floatPtr(25)},
+	}
+	for _, tt := range tests {
+		var k KpiConfig
+		for _, c := range tt.cfg.KpiConfigs {
+			if c.ID == tt.kpi { k = c }
+		}
+		current := 0.0
+		if v, ok := tt.inputs[tt.kpi]; ok { current, _ = strconv.ParseFloat(v, 64) }
+		got := solveKpi(tt.cfg, "", tt.inputs, k, current, tt.target)
+		if tt.required == nil {
+			if got.Reachable || got.Required != nil { t.Errorf("%s: got %+v, want unreachable", tt.name, got) }
+			continue
+		}
+		if !got.Reachable || got.Required == nil { t.Errorf("%s: unreachable, want %v", tt.name, *tt.required); continue }
+		if math.Abs(*got.Required-*tt.required) > 1e-9 { t.Errorf("%s: required = %v, want %v", tt.name, *got.Required, *tt.required) }
+		if math.Abs(got.Change-(*tt.required-current)) > 1e-9 { t.Errorf("%s: change = %v, want %v", tt.name, got.Change, *tt.required-current) }
+		if got.Points < tt.target.Threshold { t.Errorf("%s: points at required = %v, below %v", tt.name, got.Points, tt.target.Threshold) }
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	StatusDraft     = "draft"
+	StatusSubmitted = "submitted"
+	StatusApproved  = "approved"
+	StatusPaid      = "paid"
+	StatusRejected  = "rejected"
+)
+
+var errStaleStatus = errors.New("history entry status changed concurrently; reload and retry")
+
+// historyTransition is one edge of the approval state machine
+type historyTransition struct {
+	From []string
+	To   string
+	// allowed decides whether u may take this transition for the entry
+	allowed func(u *User, entry HistoryEntry) bool
+}
+
+func isSignOffRole(u *User, _ HistoryEntry) bool { return u.Role == RoleAdmin || u.Role == RoleHR }
+
+// Managers sign off by submitting; HR approves, rejects and marks entries paid
+var historyTransitions = map[string]historyTransition{
+	"submit":  {From: []string{StatusDraft, StatusRejected}, To: StatusSubmitted, allowed: func(u *User, e HistoryEntry) bool { return u.canManageDivision(e.DivisionID) }},
+	"approve": {From: []string{StatusSubmitted}, To: StatusApproved, allowed: isSignOffRole},
+	"reject":  {From: []string{StatusSubmitted}, To: StatusRejected, allowed: isSignOffRole},
+	"pay":     {From: []string{StatusApproved}, To: StatusPaid, allowed: isSignOffRole},
+}
+
+// isLockedStatus reports whether an entry has been signed off and may no longer change
+func isLockedStatus(status string) bool { return status == StatusApproved || status == StatusPaid }
+
+// lockedStatuses are the statuses isLockedStatus accepts, for queries
+var lockedStatuses = []string{StatusApproved, StatusPaid}
+
+// migrateHistoryStatus marks entries saved before the workflow existed as approved,
+// since they were treated as final at the time.
+func migrateHistoryStatus() {
+	res := db.Model(&HistoryEntry{}).Where("status IS NULL OR status = ''").Update("status", StatusApproved)
+	if res.Error != nil { log.Printf("Failed to backfill history status: %v", res.Error); return }
+	if res.RowsAffected > 0 { log.Printf("Marked %d legacy history entries as approved", res.RowsAffected) }
+}
+
+func registerWorkflowRoutes(r *gin.RouterGroup) {
+	// POST /history/:id/{submit,approve,reject,pay}; reject requires {"reason": "..."}
+	for action, t := range historyTransitions {
+		action, t := action, t
+		r.POST("/history/:id/"+action, func(c *gin.Context) {
+			id, ok := parseID(c)
+			if !ok { return }
+			var req struct{ Reason string `json:"reason"` }
+			if c.Request.ContentLength != 0 {
+				if err := c.ShouldBindJSON(&req); err != nil { c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()}); return }
+			}
+			req.Reason = strings.TrimSpace(req.Reason)
+			if t.To == StatusRejected && req.Reason == "" { c.JSON(http.StatusBadRequest, gin.H{"error": "reason is required to reject"}); return }
+
+			var entry HistoryEntry
+			if err := db.First(&entry, id).Error; err != nil { respondLookupError(c, "history entry", err); return }
+			u := currentUser(c)
+			if !u.canReadHistory(entry) { c.JSON(http.StatusNotFound, gin.H{"error": "history entry not found"}); return }
+			if !t.allowed(u, entry) { c.JSON(http.StatusForbidden, gin.H{"error": "not allowed to " + action + " this history entry"}); return }
+			if !entry.IsCurrent { c.JSON(http.StatusConflict, gin.H{"error": "only the current revision of a history entry can change status"}); return }
+			valid := false
+			for _, from := range t.From { valid = valid || entry.Status == from }
+			if !valid {
+				c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("cannot %s a history entry in status %q", action, entry.Status)}); return
+			}
+
+			before := entry
+			now := time.Now()
+			entry.Status, entry.StatusReason, entry.StatusChangedBy, entry.StatusChangedAt = t.To, req.Reason, u.Username, &now
+			err := db.WithContext(c).Transaction(func(tx *gorm.DB) error {
+				// Guard against a concurrent transition from the same state
+				res := tx.Model(&HistoryEntry{}).Where("id = ? AND status = ?", entry.ID, before.Status).
+					Updates(map[string]any{"status": entry.Status, "status_reason": entry.StatusReason, "status_changed_by": entry.StatusChangedBy, "status_changed_at": entry.StatusChangedAt})
+				if res.Error != nil { return res.Error }
+				if res.RowsAffected == 0 { return errStaleStatus }
+				// Payroll leaves rejected entries unpaid, so rejecting or resubmitting changes it
+				if entry.PeriodID != nil && (before.Status == StatusRejected) != (entry.Status == StatusRejected) {
+					if err := markPayrollStale(tx, entry.DivisionID, *entry.PeriodID, "history "+t.To); err != nil { return err }
+				}
+				return recordAudit(tx, AuditUpdate, "history", &before, &entry)
+			})
+			if errors.Is(err, errStaleStatus) { c.JSON(http.StatusConflict, gin.H{"error": err.Error()}); return }
+			if err != nil { c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()}); return }
+			c.JSON(http.StatusOK, toHistoryResponse(entry))
+		})
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestHistoryWorkflowLocks(t *testing.T) {
+	s := newTestServer(t)
+	d := s.seedDivision()
+	entry := s.saveHistory(d, "Januari", 2024)
+	path := fmt.Sprintf("/history/%d", entry.ID)
+	amend := gin.H{"realisasiInputs": d.Inputs, "reason": "koreksi omset"}
+
+	// Each step runs against the state the previous ones left
+	steps := []struct {
+		name   string
+		method string
+		path   string
+		body   any
+		status int
+		want   string // status of the entry afterwards
+	}{
+		{name: "pay a draft", method: http.MethodPost, path: path + "/pay", status: http.StatusConflict, want: StatusDraft},
+		{name: "submit", method: http.MethodPost, path: path + "/submit", status: http.StatusOK, want: StatusSubmitted},
+		{name: "reject without reason", method: http.MethodPost, path: path + "/reject", status: http.StatusBadRequest, want: StatusSubmitted},
+		{name: "reject", method: http.MethodPost, path: path + "/reject", body: gin.H{"reason": "omset belum final"}, status: http.StatusOK, want: StatusRejected},
+		{name: "resubmit", method: http.MethodPost, path: path + "/submit", status: http.StatusOK, want: StatusSubmitted},
+		{name: "approve", method: http.MethodPost, path: path + "/approve", status: http.StatusOK, want: StatusApproved},
+		{name: "amend approved", method: http.MethodPut, path: path, body: amend, status: http.StatusLocked, want: StatusApproved},
+		{name: "delete approved", method: http.MethodDelete, path: path, status: http.StatusLocked, want: StatusApproved},
+		{name: "force-delete its division", method: http.MethodDelete, path: fmt.Sprintf("/divisions/%d?force=true", d.ID), status: http.StatusLocked, want: StatusApproved},
+		{name: "reject approved", method: http.MethodPost, path: path + "/reject", body: gin.H{"reason": "salah"}, status: http.StatusConflict, want: StatusApproved},
+		{name: "pay", method: http.MethodPost, path: path + "/pay", status: http.StatusOK, want: StatusPaid},
+		{name: "pay twice", method: http.MethodPost, path: path + "/pay", status: http.StatusConflict, want: StatusPaid},
+		{name: "amend paid", method: http.MethodPut, path: path, body: amend, status: http.StatusLocked, want: StatusPaid},
+		{name: "delete paid", method: http.MethodDelete, path: path, status: http.StatusLocked, want: StatusPaid},
+	}
+	for _, st := range steps {
+		w := s.do(st.method, st.path, st.body)
+		if w.Code != st.status { t.Fatalf("%s: status = %d, want %d: %s", st.name, w.Code, st.status, w.Body.String()) }
+		var got HistoryEntry
+		if err := db.First(&got, entry.ID).Error; err != nil { t.Fatalf("%s: %v", st.name, err) }
+		if got.Status != st.want { t.Fatalf("%s: entry is %s, want %s", st.name, got.Status, st.want) }
+	}
+	var revisions int64
+	db.Model(&HistoryEntry{}).Where("employee_id = ? AND period_id = ?", entry.EmployeeID, *entry.PeriodID).Count(&revisions)
+	if revisions != 1 { t.Errorf("%d revisions after locked amendments, want 1", revisions) }
+}